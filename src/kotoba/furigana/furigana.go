@@ -0,0 +1,91 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package furigana
+import (
+    // System
+    "strings"
+)
+
+// <===> Furigana <============================================================>
+// Base text with its reading, plain text has no reading
+type Span struct {
+    Text string
+    Kana string
+}
+
+type SpanList []Span
+
+// Output formats, "spans" is the sentences.pipe column read back by Parse
+var Formats = []string{ "kotoba", "html", "anki", "aozora", "spans" }
+
+func (list SpanList) Add(text string, kana string) SpanList {
+    // Join plain text
+    n := len(list)
+    if len(kana) == 0 && n > 0 && len(list[n - 1].Kana) == 0 {
+        list[n - 1].Text += text
+        return list
+    }
+    return append(list, Span{ Text: text, Kana: kana })
+}
+
+func (list SpanList) Join(other SpanList) SpanList {
+    for _, span := range other { list = list.Add(span.Text, span.Kana) }
+    return list
+}
+
+func (list SpanList) Plain() string {
+    ret := ""
+    for _, span := range list { ret += span.Text }
+    return ret
+}
+
+func (list SpanList) Format(format string) string {
+    ret := ""
+    for i, span := range list {
+        if len(span.Kana) == 0 {
+            switch format {
+                case "html": ret += html_escape(span.Text)
+                case "spans":
+                    if i > 0 { ret += ";" }
+                    ret += span.Text + "@"
+                default: ret += span.Text
+            }
+            continue
+        }
+        switch format {
+            case "html": ret += "<ruby>" + html_escape(span.Text) + "<rt>" + html_escape(span.Kana) + "</rt></ruby>"
+            case "anki":
+                // Space marks where the annotated block starts
+                if i > 0 { ret += " " }
+                ret += span.Text + "[" + span.Kana + "]"
+            case "aozora": ret += "｜" + span.Text + "《" + span.Kana + "》"
+            case "spans":
+                if i > 0 { ret += ";" }
+                ret += span.Text + "@" + span.Kana
+            default: ret += "{" + span.Text + ";" + span.Kana + "}"
+        }
+    }
+    return ret
+}
+
+func Parse(str string) SpanList {
+    list := SpanList{}
+    if len(str) == 0 { return list }
+    for _, item := range strings.Split(str, ";") {
+        split := strings.SplitN(item, "@", 2)
+        if len(split) < 2 { split = append(split, "") }
+        list = append(list, Span{ Text: split[0], Kana: split[1] })
+    }
+    return list
+}
+
+func html_escape(str string) string {
+    str = strings.Replace(str, "&", "&amp;", -1)
+    str = strings.Replace(str, "<", "&lt;", -1)
+    str = strings.Replace(str, ">", "&gt;", -1)
+    return str
+}
@@ -0,0 +1,31 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package furigana
+import (
+    // System
+    "testing"
+)
+
+// Span column written by the sentence parser and read back by the merger
+func TestSpansParse(t *testing.T) {
+    list := SpanList{}.Add("私", "わたし").Add("は", "").Add("寿司", "すし").Add("を", "").Add("食", "た").Add("べます。", "")
+    str := list.Format("spans")
+    if str != "私@わたし;は@;寿司@すし;を@;食@た;べます。@" { t.Errorf("spans '%s'", str) }
+    
+    // Same spans and output in every format
+    parsed := Parse(str)
+    if len(parsed) != len(list) { t.Fatalf("parsed %d spans, expected %d", len(parsed), len(list)) }
+    for i := range list {
+        if parsed[i] != list[i] { t.Errorf("span %d: %v, expected %v", i, parsed[i], list[i]) }
+    }
+    for _, format := range Formats {
+        if parsed.Format(format) != list.Format(format) { t.Errorf("%s: '%s', expected '%s'", format, parsed.Format(format), list.Format(format)) }
+    }
+    if list.Format("kotoba") != "{私;わたし}は{寿司;すし}を{食;た}べます。" { t.Errorf("kotoba '%s'", list.Format("kotoba")) }
+    if parsed.Plain() != "私は寿司を食べます。" { t.Errorf("plain '%s'", parsed.Plain()) }
+    if len(Parse("")) != 0 { t.Errorf("empty column gives spans") }
+}
@@ -0,0 +1,49 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package kdb
+import (
+    // System
+    "strings"
+)
+
+// <===> Word flags <==========================================================>
+// Spelling and reading flags of kotoba-word.kdb and kotoba-name.kdb, the app
+// reads the same bits
+const (
+    WordFlagAteji = 1 << iota
+    WordFlagIrregular
+    WordFlagRare
+    WordFlagOutdated
+    WordFlagSearch
+    WordFlagGikun
+    WordFlagNokanji
+)
+
+var g_word_inf = map[string]int{
+    "ateji": WordFlagAteji,
+    "gikun": WordFlagGikun,
+    "iK": WordFlagIrregular,
+    "ik": WordFlagIrregular,
+    "io": WordFlagIrregular,
+    "rK": WordFlagRare,
+    "rk": WordFlagRare,
+    "oK": WordFlagOutdated,
+    "ok": WordFlagOutdated,
+    "sK": WordFlagSearch,
+    "sk": WordFlagSearch,
+}
+
+// Flags of JMdict info codes, with or without entity markup
+func WordFlags(inf []string) int {
+    flags := 0
+    for _, str := range inf {
+        str = strings.Trim(strings.Replace(strings.TrimSpace(str), "&", "", -1), ";")
+        flag, exists := g_word_inf[str]
+        if exists { flags |= flag }
+    }
+    return flags
+}
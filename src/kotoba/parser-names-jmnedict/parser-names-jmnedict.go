@@ -0,0 +1,663 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "flag"
+    "fmt"
+    "os"
+    "io"
+    "bufio"
+    "bytes"
+    "strings"
+    "strconv"
+    "unicode/utf8"
+    "encoding/binary"
+    "encoding/xml"
+    "sort"
+    
+    // Kotoba
+    "kotoba/kdb"
+)
+
+var g_bo binary.ByteOrder
+
+// <===> Data output <=========================================================>
+type DataOutput struct {
+    fs *os.File
+    wr *bufio.Writer
+}
+
+func DataOpen(fn string) *DataOutput {
+    // File
+    this := &DataOutput{}
+    var err error
+    this.fs, err = os.OpenFile(fn, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open data output file: %s\n", err.Error())
+        return nil
+    }
+    this.wr = bufio.NewWriter(this.fs)
+    
+    // Success
+    return this
+}
+
+func (this *DataOutput) Write(data []byte) {
+    this.wr.Write(data)
+}
+
+func (this *DataOutput) Close() {
+    this.wr.Flush()
+    this.fs.Close()
+}
+
+// <===> Dictionary <==========================================================>
+// Dictionary structures, JMdict entries with translations instead of senses
+type DictEntry struct {
+    Id string `xml:"ent_seq"`
+    Kele []DictKele `xml:"k_ele"`
+    Rele []DictRele `xml:"r_ele"`
+    Trans []DictTrans `xml:"trans"`
+}
+
+type DictKele struct {
+    Keb string `xml:"keb"`
+    KeInf []string `xml:"ke_inf"`
+    KePri []string `xml:"ke_pri"`
+}
+
+type DictRele struct {
+    Reb string `xml:"reb"`
+    ReNokanji *struct{} `xml:"re_nokanji"`
+    ReRestr []string `xml:"re_restr"`
+    ReInf []string `xml:"re_inf"`
+    RePri []string `xml:"re_pri"`
+}
+
+type DictTrans struct {
+    NameType []string `xml:"name_type"`
+    TransDet []string `xml:"trans_det"`
+}
+
+func dict_entity(str string) string {
+    str = strings.Replace(str, "&", "", -1)
+    return strings.Trim(str, ";")
+}
+
+// <===> Name types <==========================================================>
+type CategoryInfo struct {
+    // Info
+    Name string
+    Words NameInfoIdent
+    // Marshal
+    Id int
+    Offset int
+    Marshal []byte
+}
+
+type CategoryClass struct {
+    // Info
+    Info []*CategoryInfo
+    Label map[string]*CategoryInfo
+    // Data
+    Data []byte
+}
+
+// JMnedict name types in display order
+var g_name_type = [][]string{
+    { "surname", "Family or surname" },
+    { "masc", "Male given name" },
+    { "fem", "Female given name" },
+    { "given", "Given name" },
+    { "person", "Full name of a particular person" },
+    { "place", "Place name" },
+    { "station", "Railway station" },
+    { "company", "Company name" },
+    { "organization", "Organization name" },
+    { "product", "Product name" },
+    { "work", "Work of art, literature, music" },
+    { "char", "Character" },
+    { "creat", "Creature" },
+    { "dei", "Deity" },
+    { "doc", "Document" },
+    { "ev", "Event" },
+    { "fict", "Fiction" },
+    { "group", "Group" },
+    { "leg", "Legend" },
+    { "myth", "Mythology" },
+    { "obj", "Object" },
+    { "relig", "Religion" },
+    { "serv", "Service" },
+    { "ship", "Ship name" },
+    { "oth", "Other" },
+    { "unclass", "Unclassified name" },
+}
+
+func CategoryNew() *CategoryClass {
+    // Instance
+    this := &CategoryClass{
+        // Info
+        Info: []*CategoryInfo{},
+        Label: map[string]*CategoryInfo{},
+    }
+    
+    // Name types keep their table order
+    for i, item := range g_name_type {
+        info := &CategoryInfo{
+            Name: "Proper names/" + item[1],
+            Words: []*NameInfo{},
+            Id: i,
+        }
+        this.Info = append(this.Info, info)
+        this.Label[item[0]] = info
+    }
+    
+    // Success
+    return this
+}
+
+func (this *CategoryClass) Marshal() {
+    // Marshal data
+    offset := 0
+    for _, info := range this.Info {
+        // Reorder name list
+        sort.Sort(info.Words)
+        
+        // Data
+        info.Offset = offset
+        buf := bytes.NewBuffer(nil)
+        
+        binary.Write(buf, g_bo, uint16(len(info.Name)))
+        buf.WriteString(info.Name)
+        
+        binary.Write(buf, g_bo, uint32(len(info.Words)))
+        for _, word := range info.Words {
+            binary.Write(buf, g_bo, uint32(word.Id))
+        }
+        
+        info.Marshal = buf.Bytes()
+        offset += len(info.Marshal)
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    
+    // Number of entries
+    binary.Write(buf, g_bo, uint32(len(this.Info)))
+    
+    // Index
+    for _, info := range this.Info {
+        binary.Write(buf, g_bo, uint32(info.Offset))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    
+    // Entries
+    for _, info := range this.Info {
+        buf.Write(info.Marshal)
+    }
+    
+    // Data result
+    this.Data = buf.Bytes()
+}
+
+func (this *CategoryClass) Save(fn string) {
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(this.Data)
+    fs.Close()
+}
+
+// <===> Names <===============================================================>
+// Info structure
+type NameInfo struct {
+    // Info
+    Ident int
+    Kele []string
+    Kflag []int
+    Rele []string
+    Rflag []int
+    Rrestr [][]int
+    Trans [][]string
+    Cref []*CategoryInfo
+    // Marshal
+    Id int
+    Offset int
+    Marshal []byte
+}
+
+type NameInfoIdent []*NameInfo
+func (list NameInfoIdent) Len() int { return len(list) }
+func (list NameInfoIdent) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list NameInfoIdent) Less(i, j int) bool { return list[i].Ident < list[j].Ident }
+
+type NameRank struct {
+    Info *NameInfo
+    Rank int
+}
+
+type NameClass struct {
+    // Info
+    Info NameInfoIdent
+    BaseReal map[string][]NameRank
+    BaseKana map[string][]NameRank
+    // Data
+    Data []byte
+}
+
+func NameNew() *NameClass {
+    // Instance
+    this := &NameClass{
+        // Info
+        Info: []*NameInfo{},
+        BaseReal: map[string][]NameRank{},
+        BaseKana: map[string][]NameRank{},
+    }
+    
+    // Success
+    return this
+}
+
+func (this *NameClass) Load(fn string) bool {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return false
+    }
+    defer fs.Close()
+    
+    // XML reader, one entry at a time as the dictionary is large
+    reader := bufio.NewReader(fs)
+    decoder := xml.NewDecoder(reader)
+    decoder.Strict = false
+    for {
+        token, err := decoder.Token()
+        if err == io.EOF { break }
+        if err != nil {
+            fmt.Printf("XML error: %s\n", err.Error())
+            return false
+        }
+        start, ok := token.(xml.StartElement)
+        if !ok || start.Name.Local != "entry" { continue }
+        
+        // Entry
+        entry := DictEntry{}
+        err = decoder.DecodeElement(&entry, &start)
+        if err != nil {
+            fmt.Printf("XML error: %s\n", err.Error())
+            return false
+        }
+        this.Insert(&entry)
+        
+        if len(this.Info) % 10000 == 0 { fmt.Printf("%dk ", len(this.Info) / 1000) }
+    }
+    fmt.Printf("\n")
+    
+    // Success
+    return true
+}
+
+func (this *NameClass) Insert(entry *DictEntry) {
+    // Id
+    ident, _ := strconv.Atoi(entry.Id)
+    
+    // Entry
+    info := &NameInfo{
+        Ident: ident,
+        Kele: []string{},
+        Kflag: []int{},
+        Rele: []string{},
+        Rflag: []int{},
+        Rrestr: [][]int{},
+        Trans: [][]string{},
+        Cref: []*CategoryInfo{},
+    }
+    this.Info = append(this.Info, info)
+    
+    // Spellings and readings
+    for _, kele := range entry.Kele {
+        info.Kele = append(info.Kele, kele.Keb)
+        info.Kflag = append(info.Kflag, kdb.WordFlags(kele.KeInf))
+    }
+    for _, rele := range entry.Rele {
+        flags := kdb.WordFlags(rele.ReInf)
+        if rele.ReNokanji != nil { flags |= kdb.WordFlagNokanji }
+        restr := []int{}
+        for _, str := range rele.ReRestr {
+            for k, kele := range info.Kele {
+                if kele == str { restr = append(restr, k) }
+            }
+        }
+        info.Rele = append(info.Rele, rele.Reb)
+        info.Rflag = append(info.Rflag, flags)
+        info.Rrestr = append(info.Rrestr, restr)
+    }
+    
+    // Translations and name types
+    for _, trans := range entry.Trans {
+        info.Trans = append(info.Trans, trans.TransDet)
+        for _, str := range trans.NameType {
+            cat, exists := g_category.Label[dict_entity(str)]
+            if !exists {
+                fmt.Printf("Error: Unknown name type! id='%s', type='%s'\n", entry.Id, str)
+                continue
+            }
+            found := false
+            for _, cref := range info.Cref {
+                if cref == cat { found = true }
+            }
+            if !found {
+                info.Cref = append(info.Cref, cat)
+                cat.Words = append(cat.Words, info)
+            }
+        }
+    }
+    
+    // Kanji and kana references
+    for _, str := range info.Kele {
+        this.BaseReal[str] = append(this.BaseReal[str], NameRank{ Info: info, Rank: 0 })
+    }
+    for _, str := range info.Rele {
+        this.BaseKana[str] = append(this.BaseKana[str], NameRank{ Info: info, Rank: 0 })
+    }
+}
+
+func (this *NameClass) AssignId() {
+    // Sort list
+    sort.Sort(this.Info)
+    
+    // Assign ids
+    id := 0
+    for i := range this.Info {
+        this.Info[i].Id = id
+        id += 1
+    }
+}
+
+func (this *NameClass) Marshal() {
+    // Marshal data in the kotoba-word.kdb layout
+    offset := 0
+    for i, info := range this.Info {
+        this.Info[i].Offset = offset
+        buf := bytes.NewBuffer(nil)
+        
+        binary.Write(buf, g_bo, uint16(len(info.Kele)))
+        for k, str := range info.Kele {
+            binary.Write(buf, g_bo, uint16(len(str)))
+            buf.WriteString(str)
+            binary.Write(buf, g_bo, uint16(info.Kflag[k]))
+        }
+        
+        binary.Write(buf, g_bo, uint16(len(info.Rele)))
+        for r, str := range info.Rele {
+            binary.Write(buf, g_bo, uint16(len(str)))
+            buf.WriteString(str)
+            binary.Write(buf, g_bo, uint16(info.Rflag[r]))
+            binary.Write(buf, g_bo, uint16(len(info.Rrestr[r])))
+            for _, kref := range info.Rrestr[r] {
+                binary.Write(buf, g_bo, uint16(kref))
+            }
+        }
+        
+        binary.Write(buf, g_bo, uint16(len(info.Trans)))
+        for _, trans := range info.Trans {
+            binary.Write(buf, g_bo, uint16(len(trans)))
+            for _, str := range trans {
+                binary.Write(buf, g_bo, uint16(len(str)))
+                buf.WriteString(str)
+            }
+            
+            // No spelling or reading restrictions
+            binary.Write(buf, g_bo, uint16(0))
+            binary.Write(buf, g_bo, uint16(0))
+        }
+        
+        binary.Write(buf, g_bo, uint16(len(info.Cref)))
+        for _, cref := range info.Cref {
+            binary.Write(buf, g_bo, uint16(cref.Id))
+        }
+        
+        // No sentence references or corpus frequency
+        binary.Write(buf, g_bo, uint16(0))
+        binary.Write(buf, g_bo, uint32(0))
+        binary.Write(buf, g_bo, uint32(0))
+        
+        this.Info[i].Marshal = buf.Bytes()
+        offset += len(this.Info[i].Marshal)
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    
+    // Number of entries
+    binary.Write(buf, g_bo, uint32(len(this.Info)))
+    
+    // Index
+    for _, info := range this.Info {
+        binary.Write(buf, g_bo, uint32(info.Offset))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    
+    // Entries
+    for _, info := range this.Info {
+        buf.Write(info.Marshal)
+    }
+    
+    // Idents
+    for _, info := range this.Info {
+        binary.Write(buf, g_bo, uint32(info.Ident))
+    }
+    
+    // Data result
+    this.Data = buf.Bytes()
+}
+
+func (this *NameClass) Save(fn string) {
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(this.Data)
+    fs.Close()
+}
+
+// <===> Base tables <=========================================================>
+type BaseInfo struct {
+    // Info
+    Name string
+    Nref []NameRank
+    // Marshal
+    Id int
+    Offset int
+    Marshal []byte
+}
+
+type BaseInfoName []*BaseInfo
+func (list BaseInfoName) Len() int { return len(list) }
+func (list BaseInfoName) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list BaseInfoName) Less(i, j int) bool {
+    ra := rune_decode(list[i].Name)
+    rb := rune_decode(list[j].Name)
+    sz := len(ra)
+    if sz > len(rb) { sz = len(rb) }
+    for i := 0; i < sz; i++ {
+        if ra[i] < rb[i] {
+            return true
+        } else if ra[i] > rb[i] {
+            return false
+        }
+    }
+    return len(ra) < len(rb)
+}
+
+type BaseClass struct {
+    // Info
+    Info BaseInfoName
+    // Data
+    Data []byte
+}
+
+func BaseNew() *BaseClass {
+    // Instance
+    this := &BaseClass{
+        // Info
+        Info: []*BaseInfo{},
+    }
+    
+    // Success
+    return this
+}
+
+func (this *BaseClass) AssignId() {
+    // Sort
+    sort.Sort(this.Info)
+    
+    // Assign ids
+    id := 0
+    for _, info := range this.Info {
+        info.Id = id
+        id++
+    }
+}
+
+func (this *BaseClass) Marshal() {
+    // Marshal data in the kotoba-base_{k,f}.kdb layout
+    offset := 0
+    for i, info := range this.Info {
+        this.Info[i].Offset = offset
+        buf := bytes.NewBuffer(nil)
+
+        binary.Write(buf, g_bo, uint16(len(info.Name)))
+        buf.WriteString(info.Name)
+        
+        binary.Write(buf, g_bo, uint16(len(info.Nref)))
+        for _, nref := range info.Nref {
+            rank := uint32(nref.Rank)
+            if (rank > 15) { rank = 15 }
+            rank = rank << 28
+            binary.Write(buf, g_bo, uint32(uint32(nref.Info.Id) | rank))
+        }
+        
+        this.Info[i].Marshal = buf.Bytes()
+        offset += len(this.Info[i].Marshal)
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    
+    // Number of entries
+    binary.Write(buf, g_bo, uint32(len(this.Info)))
+    
+    // Index
+    for _, info := range this.Info {
+        binary.Write(buf, g_bo, uint32(info.Offset))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    
+    // Entries
+    for _, info := range this.Info {
+        buf.Write(info.Marshal)
+    }
+    
+    // Data result
+    this.Data = buf.Bytes()
+}
+
+func (this *BaseClass) Load(mnref map[string][]NameRank) {
+    for key, nlist := range mnref {
+        // Drop duplicate references
+        nnew := []NameRank{}
+        for _, nitem := range nlist {
+            found := false
+            for _, xitem := range nnew {
+                if xitem.Info == nitem.Info { found = true }
+            }
+            if !found { nnew = append(nnew, nitem) }
+        }
+        
+        // Entry
+        this.Info = append(this.Info, &BaseInfo{
+            Name: key,
+            Nref: nnew,
+        })
+    }
+}
+
+func (this *BaseClass) Save(fn string) {
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(this.Data)
+    fs.Close()
+}
+
+// <===> Utility <=============================================================>
+func rune_decode(str string) []rune {
+    arr := []rune{}
+    for (len(str) > 0) {
+        r, sz := utf8.DecodeRuneInString(str)
+        if (sz == 0) { break }
+        arr = append(arr, r)
+        str = str[sz:]
+    }
+    return arr
+}
+
+// <===> Main <================================================================>
+// Globals
+var g_category *CategoryClass
+var g_name *NameClass
+
+// Main function
+func main() {
+    // Flags
+    fn_n := flag.String("jmnedict", "", "JMnedict file")
+    flag.Parse()
+    if (*fn_n == "") {
+        fmt.Printf("Please specify JMnedict file!\n")
+        return
+    }
+    
+    // Byte order
+    g_bo = binary.LittleEndian
+    
+    // Classes
+    g_category = CategoryNew()
+    g_name = NameNew()
+    
+    // Load
+    fmt.Print("Loading names...\n")
+    if (!g_name.Load(*fn_n)) {
+        fmt.Printf("Error parsing names file!\n")
+        return
+    }
+    
+    // Id generation and marshalling
+    fmt.Printf("Marshalling...\n")
+    g_name.AssignId()
+    g_category.Marshal()
+    g_name.Marshal()
+    
+    // Save
+    fmt.Print("Writing data...\n")
+    g_category.Save("kotoba-name-category.kdb")
+    g_name.Save("kotoba-name.kdb")
+    
+    // Bases
+    fmt.Print("Generating bases...\n")
+    base_k := BaseNew()
+    base_k.Load(g_name.BaseReal)
+    base_f := BaseNew()
+    base_f.Load(g_name.BaseKana)
+    
+    base_k.AssignId()
+    base_f.AssignId()
+    
+    base_k.Marshal()
+    base_f.Marshal()
+    
+    base_k.Save("kotoba-name-base_k.kdb")
+    base_f.Save("kotoba-name-base_f.kdb")
+}
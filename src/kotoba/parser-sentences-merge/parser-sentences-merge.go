@@ -14,9 +14,10 @@ import (
     "strings"
     "strconv"
     "unicode/utf8"
-    "math/rand"
     "encoding/hex"
     "sort"
+    "bytes"
+    "index/suffixarray"
 )
 
 // <===> XML <=================================================================>
@@ -135,20 +136,63 @@ type WordInfo struct {
     En string
     Flags string
     Level string
+    Jlpt int
     // Meta
     Sref []WordSref
     Cref []*CategoryInfo
 }
 
 func (this* WordInfo) LimitSref(num int) {
-    // Randomly remove entries until under limit
-    for len(this.Sref) > num {
-        n := rand.Intn(len(this.Sref))
-        narr := make([]WordSref, len(this.Sref) - 1)
-        copy(narr, this.Sref[0:n])
-        copy(narr[n:], this.Sref[n + 1:])
-        this.Sref = narr
+    // Rank entries and keep the best ones
+    rank := WordSrefScore{ List: this.Sref, Score: make([]int, len(this.Sref)) }
+    for i, sref := range this.Sref { rank.Score[i] = this.SrefScore(&sref) }
+    sort.Sort(rank)
+    if len(this.Sref) > num { this.Sref = this.Sref[0:num] }
+}
+
+// Example sentence lengths without penalty
+const g_select_short = 6
+const g_select_long = 25
+
+// Example value of a sentence for the word
+func (this* WordInfo) SrefScore(sref *WordSref) int {
+    score := 0
+    
+    // Length
+    n := mark_rune_len(sref.Info.JpReal)
+    dist := 0
+    if n < g_select_short { dist = g_select_short - n }
+    if n > g_select_long { dist = n - g_select_long }
+    if dist < 10 { score += 20 - 2 * dist }
+    
+    // Vocabulary at or below the level of the word
+    target := this.Jlpt
+    if target == 0 { target = 1 }
+    known := 0
+    total := 0
+    for _, bitem := range strings.Split(sref.Info.JpBase, ";") {
+        dlist := strings.Split(bitem, "@")
+        level, exists := g_word.Level[dlist[0]]
+        if !exists { continue }
+        total += 1
+        if level >= target { known += 1 }
     }
+    if total > 0 { score += 30 * known / total }
+    return score
+}
+
+type WordSrefScore struct {
+    List []WordSref
+    Score []int
+}
+func (this WordSrefScore) Len() int { return len(this.List) }
+func (this WordSrefScore) Swap(i, j int) {
+    this.List[i], this.List[j] = this.List[j], this.List[i]
+    this.Score[i], this.Score[j] = this.Score[j], this.Score[i]
+}
+func (this WordSrefScore) Less(i, j int) bool {
+    if this.Score[i] != this.Score[j] { return this.Score[i] > this.Score[j] }
+    return this.List[i].Info.JpReal < this.List[j].Info.JpReal
 }
 
 type WordSref struct {
@@ -159,6 +203,8 @@ type WordSref struct {
 
 type WordClass struct {
     Info WordInfoSort
+    // Easiest JLPT level of each spelling
+    Level map[string]int
 }
 
 type WordInfoSort []*WordInfo
@@ -184,6 +230,7 @@ func WordNew() *WordClass {
     // Instance
     this := &WordClass{
         Info: []*WordInfo{},
+        Level: map[string]int{},
     }
     
     // Success
@@ -230,6 +277,11 @@ func (this *WordClass) Load(fn string) bool {
         jlpt_level, _ := strconv.Atoi(strings.TrimSpace(record[5]))
         cref := g_category.Jlpt[jlpt_level]
         
+        // Easiest level of each spelling
+        for _, str := range strings.Split(strings.TrimSpace(record[1]) + ";" + strings.TrimSpace(record[2]), ";") {
+            if len(str) > 0 && jlpt_level > this.Level[str] { this.Level[str] = jlpt_level }
+        }
+        
         // Word
         this.Info = append(this.Info, &WordInfo{
             // Info
@@ -239,6 +291,7 @@ func (this *WordClass) Load(fn string) bool {
             JpKana: strings.TrimSpace(record[2]),
             En: strings.TrimSpace(record[3]),
             Flags: strings.TrimSpace(record[4]),
+            Jlpt: jlpt_level,
             // Meta
             Sref: []WordSref{},
             Cref: []*CategoryInfo{ cref },
@@ -297,6 +350,10 @@ type SentenceInfo struct {
 type SentenceClass struct {
     Info []*SentenceInfo
     Base map[string][]WordSref
+    // Full-text index, byte offset of each sentence in the indexed text
+    Text *suffixarray.Index
+    TextStart []int
+    TextInfo []*SentenceInfo
 }
 
 func SentenceNew() *SentenceClass {
@@ -313,10 +370,10 @@ func SentenceNew() *SentenceClass {
 func (this *SentenceClass) AssignId() {
     id := 1
     for _, info := range this.Info {
-        //if (info.Usage > 0) {
+        if (info.Usage > 0) {
             info.Id = id
             id++
-        //}
+        }
     }
 }
 
@@ -385,10 +442,27 @@ func (this *SentenceClass) Load(fn string) bool {
         }
     }
     
+    // Full-text index
+    this.IndexText()
+    
     // Success
     return true
 }
 
+func (this *SentenceClass) IndexText() {
+    // Sentences separated by zero bytes so matches stay within one sentence
+    buf := bytes.NewBuffer(nil)
+    this.TextStart = make([]int, len(this.Info))
+    this.TextInfo = make([]*SentenceInfo, len(this.Info))
+    for i, info := range this.Info {
+        this.TextStart[i] = buf.Len()
+        this.TextInfo[i] = info
+        buf.WriteString(info.JpReal)
+        buf.WriteByte(0)
+    }
+    this.Text = suffixarray.New(buf.Bytes())
+}
+
 func (this *SentenceClass) Save(fn string) {
     // File
     xml := XmlOpen(fn)
@@ -417,18 +491,25 @@ func (this *SentenceClass) SearchBase(str string) []WordSref {
 
 func (this *SentenceClass) SearchFull(str string) []WordSref {
     list := []WordSref{}
+    if (len(str) == 0) { return list }
     mark_size := mark_rune_len(str)
-    for _, info := range this.Info {
-        index := strings.Index(info.JpReal, str)
-        if (index >= 0) {
-            mark_start := mark_rune_len(info.JpReal[0:index])
-            sref := WordSref{
-                Info: info,
-                Start: mark_start,
-                End: mark_start + mark_size,
-            }
-            list = append(list, sref)
+    
+    // Matches in sentence order, first one of each sentence
+    pos := this.Text.Lookup([]byte(str), -1)
+    sort.Ints(pos)
+    last := -1
+    for _, index := range pos {
+        n := sort.SearchInts(this.TextStart, index + 1) - 1
+        if (n == last) { continue }
+        last = n
+        info := this.TextInfo[n]
+        mark_start := mark_rune_len(info.JpReal[0:index - this.TextStart[n]])
+        sref := WordSref{
+            Info: info,
+            Start: mark_start,
+            End: mark_start + mark_size,
         }
+        list = append(list, sref)
     }
     return list
 }
@@ -451,19 +532,12 @@ var g_category *CategoryClass
 var g_word *WordClass
 var g_sentence *SentenceClass
 
-/*
-func sref_append(arr *[]WordSref, sref WordSref) []WordSref {
-    for _, item := range arr {
-        if item.Info == sref.Info { return arr }
+func sref_append(info *WordInfo, found map[*SentenceInfo]bool, list []WordSref) {
+    for _, elem := range list {
+        if found[elem.Info] { continue }
+        found[elem.Info] = true
+        info.Sref = append(info.Sref, elem)
     }
-    return append(arr, sref)
-}
-*/
-func sref_isdupe(arr []WordSref, sref *WordSref) bool {
-    for _, item := range arr {
-        if item.Info == sref.Info { return true }
-    }
-    return false
 }
 
 // Main function
@@ -505,19 +579,18 @@ func main() {
             fmt.Printf("%.01f%% ", 100.0 * float64(num) / float64(len(g_word.Info)))
         }
         
+        // Sentences already referenced
+        found := map[*SentenceInfo]bool{}
+        
         // Base word lookup with kanji
         for _, str := range strings.Split(info.JpReal, ";") {
-            for _, elem := range g_sentence.SearchBase(str) {
-                if !sref_isdupe(info.Sref[0:], &elem) { info.Sref = append(info.Sref, elem) }
-            }
+            sref_append(info, found, g_sentence.SearchBase(str))
         }
         
         // Full text search with kanji
         if (len(info.Sref) < 5) {
             for _, str := range strings.Split(info.JpReal, ";") {
-                for _, elem := range g_sentence.SearchFull(str) {
-                    if !sref_isdupe(info.Sref[0:], &elem) { info.Sref = append(info.Sref, elem) }
-                }
+                sref_append(info, found, g_sentence.SearchFull(str))
             }
         }
 
@@ -525,17 +598,13 @@ func main() {
         if (len(info.JpKana) > 0 && len(info.Sref) < 2) {
             // Base word lookup with kanji
             for _, str := range strings.Split(info.JpKana, ";") {
-                for _, elem := range g_sentence.SearchBase(str) {
-                    if !sref_isdupe(info.Sref[0:], &elem) { info.Sref = append(info.Sref, elem) }
-                }
+                sref_append(info, found, g_sentence.SearchBase(str))
             }
             
             // Full text search with kanji
             if (len(info.Sref) == 0) {
                 for _, str := range strings.Split(info.JpKana, ";") {
-                    for _, elem := range g_sentence.SearchFull(str) {
-                        if !sref_isdupe(info.Sref[0:], &elem) { info.Sref = append(info.Sref, elem) }
-                    }
+                    sref_append(info, found, g_sentence.SearchFull(str))
                 }
             }
         }
@@ -0,0 +1,180 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "bufio"
+    "container/heap"
+)
+
+// <===> Coverage <============================================================>
+// Words with at least this many examples are covered
+var g_cover_min int
+
+// Word weight from its highest priority word list category, lower order first.
+// Example corpus frequency categories come from the sentences and are skipped.
+func cover_weight(info *WordInfo) int {
+    weight := 1
+    for _, c := range info.Cref {
+        if c.Order >= g_freq_order { continue }
+        if 200 - c.Order > weight { weight = 200 - c.Order }
+    }
+    return weight
+}
+
+// Bytes of a sentence in the sentence data with its index entry
+func cover_size(info *SentenceInfo) int {
+    return 4 + 2 + len(info.JpKana) + 2 + len(info.En) + 8 + 6 + 2 + 2 * len(info.Also)
+}
+
+// Sentence candidate with gain cached from an earlier round
+type CoverItem struct {
+    Info *SentenceInfo
+    Words []*WordInfo
+    Gain float64
+}
+
+type CoverHeap []*CoverItem
+func (list CoverHeap) Len() int { return len(list) }
+func (list CoverHeap) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list CoverHeap) Less(i, j int) bool {
+    if list[i].Gain != list[j].Gain { return list[i].Gain > list[j].Gain }
+    return list[i].Info.Before(list[j].Info)
+}
+func (list *CoverHeap) Push(x interface{}) { *list = append(*list, x.(*CoverItem)) }
+func (list *CoverHeap) Pop() interface{} {
+    old := *list
+    item := old[len(old) - 1]
+    *list = old[0:len(old) - 1]
+    return item
+}
+
+// Greedy selection of sentences maximising weighted words with enough examples under a budget
+func (this *WordClass) Cover(max_sentences int, max_bytes int) {
+    // Candidate sentences and the words they serve
+    items := map[*SentenceInfo]*CoverItem{}
+    order := []*CoverItem{}
+    weight := map[*WordInfo]int{}
+    count := map[*WordInfo]int{}
+    for _, info := range this.Info {
+        weight[info] = cover_weight(info)
+        for _, sref := range info.Sref {
+            item, exists := items[sref.Info]
+            if !exists {
+                item = &CoverItem{ Info: sref.Info }
+                items[sref.Info] = item
+                order = append(order, item)
+            }
+            item.Words = append(item.Words, info)
+        }
+    }
+    
+    // Gain of a sentence per byte when the budget is in bytes
+    gain := func(item *CoverItem) float64 {
+        total := 0
+        for _, info := range item.Words {
+            if count[info] < g_cover_min { total += weight[info] }
+        }
+        if max_bytes > 0 { return float64(total) / float64(cover_size(item.Info)) }
+        return float64(total)
+    }
+    list := CoverHeap{}
+    for _, item := range order {
+        item.Gain = gain(item)
+        list = append(list, item)
+    }
+    heap.Init(&list)
+    
+    // Lazy greedy, gains only decrease as words get their examples
+    chosen := map[*SentenceInfo]bool{}
+    num := 0
+    size := 0
+    for list.Len() > 0 {
+        item := heap.Pop(&list).(*CoverItem)
+        value := gain(item)
+        if value != item.Gain {
+            item.Gain = value
+            if value > 0 { heap.Push(&list, item) }
+            continue
+        }
+        if value <= 0 { break }
+        if max_sentences > 0 && num + 1 > max_sentences { break }
+        if max_bytes > 0 && size + cover_size(item.Info) > max_bytes { continue }
+        chosen[item.Info] = true
+        num += 1
+        size += cover_size(item.Info)
+        for _, info := range item.Words { count[info] += 1 }
+    }
+    
+    // Keep chosen sentences in ranked order
+    for _, info := range this.Info {
+        list := []*SentenceBref{}
+        for _, sref := range info.Sref {
+            if chosen[sref.Info] { list = append(list, sref) }
+        }
+        info.Sref = list
+    }
+}
+
+// Number of word references of each sentence
+func (this *WordClass) Usage() {
+    for _, info := range this.Info {
+        for _, sref := range info.Sref { sref.Info.Usage += 1 }
+    }
+}
+
+// Words with enough examples by category
+func (this *WordClass) CoverReport(fn string) {
+    // File
+    fs, err := os.OpenFile(fn, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open coverage report file: %s\n", err.Error())
+        return
+    }
+    defer fs.Close()
+    wr := bufio.NewWriter(fs)
+    defer wr.Flush()
+    
+    // Sentences and their size
+    seen := map[*SentenceInfo]bool{}
+    size := 0
+    covered := 0
+    weighted := 0
+    weight_total := 0
+    for _, info := range this.Info {
+        for _, sref := range info.Sref {
+            if seen[sref.Info] { continue }
+            seen[sref.Info] = true
+            size += cover_size(sref.Info)
+        }
+        weight := cover_weight(info)
+        weight_total += weight
+        if len(info.Sref) >= g_cover_min {
+            covered += 1
+            weighted += weight
+        }
+    }
+    percent := 0.0
+    if weight_total > 0 { percent = 100.0 * float64(weighted) / float64(weight_total) }
+    str := fmt.Sprintf("Coverage: %d sentences (%d bytes), %d of %d words with %d or more examples (%.1f%% weighted)\n",
+        len(seen), size, covered, len(this.Info), g_cover_min, percent)
+    fmt.Printf("%s", str)
+    wr.WriteString(str)
+    
+    // Categories
+    for _, c := range g_category.Info {
+        num := 0
+        for _, info := range c.Words {
+            if len(info.Sref) >= g_cover_min { num += 1 }
+        }
+        percent := 0.0
+        if len(c.Words) > 0 { percent = 100.0 * float64(num) / float64(len(c.Words)) }
+        wr.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.1f%%\n", c.Name, len(c.Words), num, percent))
+    }
+}
@@ -0,0 +1,239 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "strings"
+)
+
+// <===> Deinflection <========================================================>
+// Word types, the surface form as found in text is any of them
+const (
+    DeinfInitial = 1 << iota
+    DeinfV1
+    DeinfV5
+    DeinfVk
+    DeinfSuru
+    DeinfVs
+    DeinfAdjI
+    DeinfAdjNa
+    DeinfTe
+)
+const DeinfAny = DeinfInitial | DeinfV1 | DeinfV5 | DeinfVk | DeinfSuru | DeinfVs | DeinfAdjI | DeinfAdjNa | DeinfTe
+
+// JMdict part of speech codes, godan verbs by prefix
+var g_deinf_pos = map[string]int{
+    "v1": DeinfV1,
+    "v1-s": DeinfV1,
+    "vk": DeinfVk,
+    "vs": DeinfVs,
+    "vs-i": DeinfSuru,
+    "vs-s": DeinfSuru,
+    "adj-i": DeinfAdjI,
+    "adj-ix": DeinfAdjI,
+    "adj-na": DeinfAdjNa,
+}
+
+func deinf_pos(pos string) int {
+    types := 0
+    for _, str := range strings.Split(pos, ";") {
+        str = strings.TrimSpace(str)
+        if strings.HasPrefix(str, "v5") { types |= DeinfV5 }
+        types |= g_deinf_pos[str]
+    }
+    return types
+}
+
+// Inflected ending replaced with the ending of the result, the source must have one of the In types
+type DeinfRule struct {
+    From string
+    To string
+    In int
+    Out int
+}
+
+type DeinfForm struct {
+    Text string
+    Type int
+}
+
+// Godan rows: dictionary ending, a, i, e, o, te and ta forms
+var g_deinf_godan = [][]string{
+    { "う", "わ", "い", "え", "お", "って", "った" },
+    { "く", "か", "き", "け", "こ", "いて", "いた" },
+    { "ぐ", "が", "ぎ", "げ", "ご", "いで", "いだ" },
+    { "す", "さ", "し", "せ", "そ", "して", "した" },
+    { "つ", "た", "ち", "て", "と", "って", "った" },
+    { "ぬ", "な", "に", "ね", "の", "んで", "んだ" },
+    { "ぶ", "ば", "び", "べ", "ぼ", "んで", "んだ" },
+    { "む", "ま", "み", "め", "も", "んで", "んだ" },
+    { "る", "ら", "り", "れ", "ろ", "って", "った" },
+}
+
+// Polite endings after the conjunctive stem
+var g_deinf_masu = []string{ "ます", "ました", "ません", "ませんでした", "ましょう", "まして" }
+
+// Adjectival noun endings
+var g_deinf_na = []string{ "な", "に", "だ", "だった", "です", "でした", "なら", "だろう", "じゃない", "ではない", "じゃなかった", "ではなかった" }
+
+// Irregular verb forms
+var g_deinf_irregular = []DeinfRule{
+    DeinfRule{ "行って", "行く", DeinfInitial | DeinfTe, DeinfV5 },
+    DeinfRule{ "行った", "行く", DeinfInitial, DeinfV5 },
+    DeinfRule{ "いって", "いく", DeinfInitial | DeinfTe, DeinfV5 },
+    DeinfRule{ "いった", "いく", DeinfInitial, DeinfV5 },
+    DeinfRule{ "こない", "くる", DeinfAdjI, DeinfVk },
+    DeinfRule{ "きた", "くる", DeinfInitial, DeinfVk },
+    DeinfRule{ "きて", "くる", DeinfInitial | DeinfTe, DeinfVk },
+    DeinfRule{ "こられる", "くる", DeinfV1, DeinfVk },
+    DeinfRule{ "こさせる", "くる", DeinfV1, DeinfVk },
+    DeinfRule{ "こよう", "くる", DeinfInitial, DeinfVk },
+    DeinfRule{ "くれば", "くる", DeinfInitial, DeinfVk },
+    DeinfRule{ "きたら", "くる", DeinfInitial, DeinfVk },
+    DeinfRule{ "きたい", "くる", DeinfAdjI, DeinfVk },
+    DeinfRule{ "こい", "くる", DeinfInitial, DeinfVk },
+    DeinfRule{ "しない", "する", DeinfAdjI, DeinfSuru },
+    DeinfRule{ "した", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "して", "する", DeinfInitial | DeinfTe, DeinfSuru },
+    DeinfRule{ "される", "する", DeinfV1, DeinfSuru },
+    DeinfRule{ "させる", "する", DeinfV1, DeinfSuru },
+    DeinfRule{ "できる", "する", DeinfV1, DeinfSuru },
+    DeinfRule{ "しよう", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "すれば", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "したら", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "したい", "する", DeinfAdjI, DeinfSuru },
+    DeinfRule{ "しろ", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "せよ", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "せず", "する", DeinfInitial, DeinfSuru },
+    DeinfRule{ "する", "", DeinfSuru, DeinfVs },
+}
+
+var g_deinf_rules []DeinfRule
+
+// Longest inflection looked at after a stem, in characters
+const g_deinf_max = 10
+
+func deinf_init() {
+    rules := append([]DeinfRule{}, g_deinf_irregular...)
+    add := func(from string, to string, in int, out int) {
+        rules = append(rules, DeinfRule{ From: from, To: to, In: in, Out: out })
+    }
+    
+    // Ichidan and kuru, kanji kuru conjugates like ichidan in writing
+    for _, item := range [][]string{ { "る", "" }, { "来る", "来" } } {
+        to := item[0]
+        stem := item[1]
+        out := DeinfV1
+        if stem != "" { out = DeinfVk }
+        add(stem + "ない", to, DeinfAdjI, out)
+        add(stem + "た", to, DeinfInitial, out)
+        add(stem + "て", to, DeinfInitial | DeinfTe, out)
+        add(stem + "られる", to, DeinfV1, out)
+        add(stem + "させる", to, DeinfV1, out)
+        add(stem + "よう", to, DeinfInitial, out)
+        add(stem + "れば", to, DeinfInitial, out)
+        add(stem + "たら", to, DeinfInitial, out)
+        add(stem + "たり", to, DeinfInitial, out)
+        add(stem + "たい", to, DeinfAdjI, out)
+        add(stem + "ず", to, DeinfInitial, out)
+        add(stem + "ながら", to, DeinfInitial, out)
+        for _, str := range g_deinf_masu { add(stem + str, to, DeinfInitial, out) }
+        if stem == "" {
+            add("ろ", to, DeinfInitial, out)
+            add("れる", to, DeinfV1, out)
+        }
+    }
+    
+    // Godan
+    for _, row := range g_deinf_godan {
+        add(row[1] + "ない", row[0], DeinfAdjI, DeinfV5)
+        add(row[6], row[0], DeinfInitial, DeinfV5)
+        add(row[5], row[0], DeinfInitial | DeinfTe, DeinfV5)
+        add(row[1] + "れる", row[0], DeinfV1, DeinfV5)
+        add(row[1] + "せる", row[0], DeinfV1, DeinfV5)
+        add(row[3] + "る", row[0], DeinfV1, DeinfV5)
+        add(row[4] + "う", row[0], DeinfInitial, DeinfV5)
+        add(row[3] + "ば", row[0], DeinfInitial, DeinfV5)
+        add(row[6] + "ら", row[0], DeinfInitial, DeinfV5)
+        add(row[6] + "り", row[0], DeinfInitial, DeinfV5)
+        add(row[2] + "たい", row[0], DeinfAdjI, DeinfV5)
+        add(row[1] + "ず", row[0], DeinfInitial, DeinfV5)
+        add(row[2] + "ながら", row[0], DeinfInitial, DeinfV5)
+        add(row[3], row[0], DeinfInitial, DeinfV5)
+        for _, str := range g_deinf_masu { add(row[2] + str, row[0], DeinfInitial, DeinfV5) }
+    }
+    
+    // Progressive, te form followed by iru
+    for _, str := range []string{ "て", "で" } {
+        add(str + "いる", str, DeinfV1, DeinfTe)
+        add(str + "る", str, DeinfV1, DeinfTe)
+    }
+    
+    // Adjectives
+    add("くない", "い", DeinfAdjI, DeinfAdjI)
+    add("かった", "い", DeinfInitial, DeinfAdjI)
+    add("くて", "い", DeinfInitial | DeinfTe, DeinfAdjI)
+    add("く", "い", DeinfInitial, DeinfAdjI)
+    add("ければ", "い", DeinfInitial, DeinfAdjI)
+    add("かったら", "い", DeinfInitial, DeinfAdjI)
+    add("さ", "い", DeinfInitial, DeinfAdjI)
+    add("そう", "い", DeinfInitial, DeinfAdjI)
+    add("すぎる", "い", DeinfV1, DeinfAdjI)
+    for _, str := range g_deinf_na { add(str, "", DeinfInitial, DeinfAdjNa) }
+    add("で", "", DeinfInitial | DeinfTe, DeinfAdjNa)
+    
+    g_deinf_rules = rules
+}
+
+// Candidate dictionary forms of a surface string, the string itself included
+func Deinflect(str string) []DeinfForm {
+    ret := []DeinfForm{ DeinfForm{ Text: str, Type: DeinfAny } }
+    seen := map[DeinfForm]bool{ ret[0]: true }
+    for i := 0; i < len(ret); i++ {
+        form := ret[i]
+        for _, rule := range g_deinf_rules {
+            if form.Type & rule.In == 0 { continue }
+            if !strings.HasSuffix(form.Text, rule.From) { continue }
+            if len(form.Text) == len(rule.From) && len(rule.To) == 0 { continue }
+            item := DeinfForm{ Text: form.Text[0:len(form.Text) - len(rule.From)] + rule.To, Type: rule.Out }
+            if seen[item] { continue }
+            seen[item] = true
+            ret = append(ret, item)
+        }
+    }
+    return ret
+}
+
+// Surface is an inflection of the dictionary word of the given types
+func deinf_match(surface string, word string, types int) bool {
+    for _, form := range Deinflect(surface) {
+        if form.Text == word && form.Type & types != 0 { return true }
+    }
+    return false
+}
+
+// Unchanging beginnings of the word in its inflections
+func deinf_stem(word string, types int) []string {
+    ret := []string{}
+    add := func(str string) {
+        if len(str) == 0 { return }
+        for _, item := range ret {
+            if item == str { return }
+        }
+        ret = append(ret, str)
+    }
+    if types & (DeinfV1 | DeinfVk) != 0 { add(strings.TrimSuffix(word, "る")) }
+    if types & DeinfV5 != 0 {
+        arr := []rune(word)
+        if len(arr) > 1 { add(string(arr[0:len(arr) - 1])) }
+        if strings.HasSuffix(word, "行く") || strings.HasSuffix(word, "いく") { add(strings.TrimSuffix(word, "く")) }
+    }
+    if types & DeinfSuru != 0 { add(strings.TrimSuffix(word, "する")) }
+    if types & (DeinfVs | DeinfAdjNa) != 0 { add(word) }
+    if types & DeinfAdjI != 0 { add(strings.TrimSuffix(word, "い")) }
+    return ret
+}
@@ -0,0 +1,69 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "testing"
+)
+
+// Inflected surfaces and their dictionary forms
+func TestDeinflect(t *testing.T) {
+    deinf_init()
+    cases := []struct {
+        surface string
+        word string
+        types int
+    }{
+        { "食べた", "食べる", DeinfV1 },
+        { "行かなかった", "行く", DeinfV5 },
+        { "静かで", "静か", DeinfAdjNa },
+        { "来ない", "来る", DeinfVk },
+        { "勉強した", "勉強", DeinfVs },
+        { "読んでいる", "読む", DeinfV5 },
+        { "高くなかった", "高い", DeinfAdjI },
+    }
+    for _, item := range cases {
+        if !deinf_match(item.surface, item.word, item.types) { t.Errorf("%s: not a form of %s", item.surface, item.word) }
+    }
+    
+    // Wrong word type
+    if deinf_match("食べた", "食べる", DeinfV5) { t.Errorf("食べた: godan form of 食べる") }
+    if deinf_match("高くなかった", "高い", DeinfAdjNa) { t.Errorf("高くなかった: adjectival noun form of 高い") }
+}
+
+// Sentence search finds inflections but not the plain dictionary form
+func TestSearchInflected(t *testing.T) {
+    deinf_init()
+    sentences := SentenceNew()
+    for _, str := range []string{ "昨日勉強した。", "勉強が好きです。", "静かな部屋。", "とても静か。" } {
+        sentences.Info = append(sentences.Info, &SentenceInfo{ JpReal: str })
+    }
+    sentences.IndexText()
+    
+    // Word, types and expected sentences with the inflected span
+    cases := []struct {
+        word string
+        types int
+        expect []string
+    }{
+        { "勉強", DeinfVs, []string{ "昨日勉強した。@2@6" } },
+        { "静か", DeinfAdjNa, []string{ "静かな部屋。@0@3" } },
+    }
+    for _, item := range cases {
+        list := sentences.SearchInflected(item.word, item.types, false)
+        if len(list) != len(item.expect) {
+            t.Errorf("%s: %d sentences, expected %d", item.word, len(list), len(item.expect))
+            continue
+        }
+        for i, sref := range list {
+            str := fmt.Sprintf("%s@%d@%d", sref.Info.JpReal, sref.Start, sref.End)
+            if str != item.expect[i] { t.Errorf("%s: found '%s', expected '%s'", item.word, str, item.expect[i]) }
+            if !sref.Inflected { t.Errorf("%s: not marked inflected", item.word) }
+        }
+    }
+}
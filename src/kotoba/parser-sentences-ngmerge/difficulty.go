@@ -0,0 +1,132 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "bytes"
+    "sort"
+    "unicode"
+    "encoding/binary"
+)
+
+// <===> Difficulty <==========================================================>
+// Easiest JLPT level of each kanji, from the listed words written with it
+func (this *WordClass) KanjiLevel() map[rune]int {
+    ret := map[rune]int{}
+    for _, info := range this.Info {
+        if info.Jlpt == 0 { continue }
+        for _, kele := range info.Kele {
+            for _, r := range kele {
+                if unicode.Is(unicode.Han, r) && info.Jlpt > ret[r] { ret[r] = info.Jlpt }
+            }
+        }
+    }
+    return ret
+}
+
+// Hardest level of words and kanji, unlisted words and kanji and combined score of each sentence.
+// Level is 0 when nothing in the sentence is listed but some of it is not.
+func (this *SentenceClass) Grade(words *WordClass) {
+    kanji := words.KanjiLevel()
+    for _, info := range this.Info {
+        level := 5
+        listed := 0
+        outside := 0
+        unlisted := 0
+        for _, base := range info.Base {
+            if sentence_kana(base) { continue }
+            value := words.Level[base]
+            if value == 0 {
+                outside += 1
+                continue
+            }
+            listed += 1
+            if value < level { level = value }
+        }
+        for _, r := range info.JpReal {
+            if !unicode.Is(unicode.Han, r) { continue }
+            value := kanji[r]
+            if value == 0 {
+                unlisted += 1
+                continue
+            }
+            listed += 1
+            if value < level { level = value }
+        }
+        if listed == 0 && outside + unlisted > 0 { level = 0 }
+        info.Level = level
+        info.Outside = outside
+        info.Unlisted = unlisted
+        
+        // Unknown level counts as one step past N1
+        steps := 5 - level
+        if level == 0 { steps = 5 }
+        info.Difficulty = 20 * steps + 8 * outside + 8 * unlisted + mark_rune_len(info.JpReal) / 4
+    }
+}
+
+// Graded sentence lists, sentences with every word and kanji at the level or easier
+type SentenceGrade struct {
+    Name string
+    Info SentenceInfoDifficulty
+}
+
+type SentenceInfoDifficulty []*SentenceInfo
+func (list SentenceInfoDifficulty) Len() int { return len(list) }
+func (list SentenceInfoDifficulty) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list SentenceInfoDifficulty) Less(i, j int) bool {
+    if list[i].Difficulty != list[j].Difficulty { return list[i].Difficulty < list[j].Difficulty }
+    return list[i].Id < list[j].Id
+}
+
+func (this *SentenceClass) GradeSave(fn string) {
+    // Levels from easiest
+    grades := []*SentenceGrade{}
+    for level := 5; level > 0; level-- {
+        grade := &SentenceGrade{ Name: fmt.Sprintf("Example sentences/JLPT N%d level", level) }
+        for _, info := range this.Info {
+            if info.Level >= level && info.Outside == 0 && info.Unlisted == 0 { grade.Info = append(grade.Info, info) }
+        }
+        sort.Sort(grade.Info)
+        grades = append(grades, grade)
+    }
+    
+    // Marshal data
+    offset := 0
+    data := [][]byte{}
+    offsets := []int{}
+    for _, grade := range grades {
+        buf := bytes.NewBuffer(nil)
+        binary.Write(buf, g_bo, uint16(len(grade.Name)))
+        buf.WriteString(grade.Name)
+        binary.Write(buf, g_bo, uint32(len(grade.Info)))
+        for _, info := range grade.Info {
+            binary.Write(buf, g_bo, uint32(info.Id))
+        }
+        offsets = append(offsets, offset)
+        data = append(data, buf.Bytes())
+        offset += buf.Len()
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    binary.Write(buf, g_bo, uint32(len(grades)))
+    for _, item := range offsets {
+        binary.Write(buf, g_bo, uint32(item))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    for _, item := range data {
+        buf.Write(item)
+    }
+    
+    // Save
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(buf.Bytes())
+    fs.Close()
+}
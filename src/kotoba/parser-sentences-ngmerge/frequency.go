@@ -0,0 +1,119 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "sort"
+)
+
+// <===> Corpus frequency <====================================================>
+// Frequency categories, words per category like the newspaper ones
+const g_freq_categories = 48
+const g_freq_size = 500
+const g_freq_order = 151
+
+func freq_label(n int) string {
+    return fmt.Sprintf("cf%02d", n)
+}
+
+// Categories for corpus frequency ranks
+func (this *CategoryClass) LoadFrequency() {
+    for n := 1; n <= g_freq_categories; n++ {
+        label := freq_label(n)
+        if _, exists := this.Label[label]; exists { continue }
+        info := &CategoryInfo{
+            Label: label,
+            Name: fmt.Sprintf("Example sentence corpus/Most frequent in example corpus #%02d", n),
+            Order: g_freq_order + n - 1,
+            Words: []*WordInfo{},
+        }
+        this.Info = append(this.Info, info)
+        this.Label[label] = info
+    }
+}
+
+type WordInfoFreq []*WordInfo
+func (list WordInfoFreq) Len() int { return len(list) }
+func (list WordInfoFreq) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list WordInfoFreq) Less(i, j int) bool {
+    if list[i].Freq != list[j].Freq { return list[i].Freq > list[j].Freq }
+    return list[i].Ident < list[j].Ident
+}
+
+// Occurrence in a sentence, analyzer token, corpus link and inflected match of
+// the same place count once
+type WordOccurrence struct {
+    Info *SentenceInfo
+    Start int
+}
+
+// Occurrences of the word as base form, corpus link or inflected form
+func (this *WordClass) Count(info *WordInfo) int {
+    // Base form spellings, readings only for kana words
+    base := map[string]bool{}
+    for _, str := range info.Kele { base[str] = true }
+    if len(info.Kele) == 0 {
+        for _, str := range info.Rele { base[str] = true }
+    }
+    head := map[string]bool{}
+    for _, str := range info.Heads() { head[str] = true }
+    reading := map[string]bool{}
+    for _, str := range info.Rele { reading[str] = true }
+    
+    // Sentences with the word
+    sentences := map[*SentenceInfo]bool{}
+    for str := range head {
+        for _, item := range g_sentence.Link[str] { sentences[item.Info] = true }
+    }
+    for str := range base {
+        for _, item := range g_sentence.BaseReal[str] { sentences[item.Info] = true }
+    }
+    
+    // Tokens and links in them
+    seen := map[WordOccurrence]bool{}
+    for sentence := range sentences {
+        for _, token := range sentence.Token {
+            if token.Link {
+                if !head[token.Real] || (len(token.Kana) > 0 && !reading[token.Kana]) { continue }
+            } else if !base[token.Real] {
+                continue
+            }
+            seen[WordOccurrence{ Info: sentence, Start: token.Start }] = true
+        }
+    }
+    
+    // Inflected forms the analyzer did not resolve
+    if info.Deinf != 0 {
+        spelling := info.Kele
+        if len(spelling) == 0 { spelling = info.Rele }
+        for _, str := range spelling {
+            for _, item := range g_sentence.SearchInflected(str, info.Deinf, len(info.Kele) == 0) {
+                seen[WordOccurrence{ Info: item.Info, Start: item.Start }] = true
+            }
+        }
+    }
+    return len(seen)
+}
+
+// Occurrence counts, frequency ranks and categories of the most frequent words
+func (this *WordClass) Frequency() {
+    list := WordInfoFreq{}
+    for _, info := range this.Info {
+        info.Freq = this.Count(info)
+        if info.Freq > 0 { list = append(list, info) }
+    }
+    sort.Sort(list)
+    for i, info := range list {
+        info.Rank = i + 1
+        n := i / g_freq_size + 1
+        if n > g_freq_categories { continue }
+        c := g_category.Label[freq_label(n)]
+        c.Words = append(c.Words, info)
+        info.Cref = append(info.Cref, c)
+    }
+}
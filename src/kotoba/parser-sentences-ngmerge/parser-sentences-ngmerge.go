@@ -20,8 +20,17 @@ import (
     "encoding/binary"
     "encoding/xml"
     "sort"
-    "math/rand"
     "runtime"
+    "context"
+    "sync"
+    "time"
+    "os/signal"
+    "index/suffixarray"
+    "flag"
+    
+    // Kotoba
+    "kotoba/kdb"
+    "kotoba/furigana"
 )
 
 var g_bo binary.ByteOrder
@@ -65,7 +74,9 @@ func (this *DataOutput) Close() {
 // <===> Categories <==========================================================>
 type CategoryInfo struct {
     // Info
+    Label string
     Name string
+    Order int
     Words WordInfoIdent
     // Marshal
     Id int
@@ -76,7 +87,10 @@ type CategoryInfo struct {
 type CategoryInfoName []*CategoryInfo
 func (list CategoryInfoName) Len() int { return len(list) }
 func (list CategoryInfoName) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
-func (list CategoryInfoName) Less(i, j int) bool { return list[i].Name < list[j].Name }
+func (list CategoryInfoName) Less(i, j int) bool {
+    if list[i].Order != list[j].Order { return list[i].Order < list[j].Order }
+    return list[i].Name < list[j].Name
+}
 
 type CategoryClass struct {
     // Info
@@ -154,56 +168,63 @@ func (this *CategoryClass) Marshal() {
     this.Data = buf.Bytes()
 }
 
-func (this *CategoryClass) Load() bool {
-    // Default JLPT levels
-    for i := 5; i > 0; i-- {
+func (this *CategoryClass) Load(fn string) bool {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return false
+    }
+    defer fs.Close()
+    
+    // Line reader, "label<tab>order<tab>folder<tab>name" per line
+    reader := bufio.NewReader(fs)
+    err = nil
+    for err == nil {
+        // Read line
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil && len(line) == 0) { break }
+        
+        // Skip comments and empty lines
+        if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") { continue }
+        
+        // Split
+        record := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+        if (len(record) < 4) {
+            fmt.Printf("Error: Line does not have enough columns! num=%d\n", len(record))
+            continue
+        }
+        
+        // Values
+        label := strings.TrimSpace(record[0])
+        order, _ := strconv.Atoi(strings.TrimSpace(record[1]))
+        folder := strings.TrimSpace(record[2])
+        name := strings.TrimSpace(record[3])
+        _, exists := this.Label[label]
+        if exists {
+            fmt.Printf("Error: Category label defined twice! label='%s'\n", label)
+            continue
+        }
+        
         // Category
         info := &CategoryInfo{
-            Name: "Japanese-Language Proficiency Test/JLPT N" + strconv.Itoa(i),
+            Label: label,
+            Name: folder + "/" + name,
+            Order: order,
             Words: []*WordInfo{},
         }
         
         // Insert
         this.Info = append(this.Info, info)
-        this.Label["n" + strconv.Itoa(i)] = info
-    }
-    
-    // Mainichi Shinbun most popular
-    info_news1 := &CategoryInfo{
-        Name: "Mainichi Shimbun newspaper/Common words #1",
-        Words: []*WordInfo{},
-    }
-    this.Info = append(this.Info, info_news1)
-    this.Label["news1"] = info_news1
-    
-    info_news2 := &CategoryInfo{
-        Name: "Mainichi Shimbun newspaper/Common words #2",
-        Words: []*WordInfo{},
+        this.Label[label] = info
     }
-    this.Info = append(this.Info, info_news2)
-    this.Label["news2"] = info_news2
     
-    // Ichimango goi bunruishuu most popular
-    info_ichi1 := &CategoryInfo{
-        Name: "Ichimango goi bunruishuu/Common words",
-        Words: []*WordInfo{},
-    }
-    this.Info = append(this.Info, info_ichi1)
-    this.Label["ichi1"] = info_ichi1
-    
-    // Frequency of use words
-    for i := 0; i < 20; i += 1 {
-        name_freq := fmt.Sprintf("Mainichi Shimbun newspaper/Most frequent words #%02d", i + 1)
-        info_freq := &CategoryInfo{
-            Name: name_freq,
-            Words: []*WordInfo{},
-        }
-        this.Info = append(this.Info, info_freq)
-        this.Label[fmt.Sprintf("nf%02d", i + 1)] = info_freq
-    }
+    // Corpus frequency categories
+    this.LoadFrequency()
     
     // Success
-    return true
+    return len(this.Info) > 0
 }
 
 func (this *CategoryClass) Save(fn string) {
@@ -222,15 +243,43 @@ type WordSaveRoot struct {
 
 type WordSaveEntry struct {
     Id string
-    Kele []string
-    Rele []string
+    Kele []WordSaveKele
+    Rele []WordSaveRele
     Sense []WordSaveSense
     Cat []string
 }
 
+type WordSaveKele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+}
+
+type WordSaveRele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+    Restr string `xml:"restr,attr,omitempty"`
+    Nokanji bool `xml:"nokanji,attr,omitempty"`
+}
+
 type WordSaveSense struct {
     Pos string
+    Misc string
     Gloss []string
+    Stagk []string
+    Stagr []string
+}
+
+func word_refs(list []string, names []string) []int {
+    ret := []int{}
+    for _, name := range names {
+        for i, str := range list {
+            if str == strings.TrimSpace(name) {
+                ret = append(ret, i)
+                break
+            }
+        }
+    }
+    return ret
 }
 
 // Info structure
@@ -238,7 +287,19 @@ type WordInfo struct {
     // Info
     Ident int
     Kele []string
+    Kflag []int
     Rele []string
+    Rflag []int
+    Rrestr [][]int
+    // Deinflection types from part of speech
+    Deinf int
+    // Easiest JLPT level, 5 to 1 or 0 when not listed
+    Jlpt int
+    // Usually written in kana
+    Kana bool
+    // Occurrences in corpus and rank by that, 0 when not found
+    Freq int
+    Rank int
     Sense []WordSaveSense
     Cref []*CategoryInfo
     Sref []*SentenceBref
@@ -261,6 +322,7 @@ type WordRank struct {
 type WordClass struct {
     // Info
     Info WordInfoIdent
+    Level map[string]int
     BaseReal map[string][]WordRank
     BaseKana map[string][]WordRank
     BaseEn map[string][]WordRank
@@ -273,6 +335,7 @@ func WordNew() *WordClass {
     this := &WordClass{
         // Info
         Info: []*WordInfo{},
+        Level: map[string]int{},
         BaseReal: map[string][]WordRank{},
         BaseKana: map[string][]WordRank{},
         BaseEn: map[string][]WordRank{},
@@ -295,17 +358,6 @@ func (this *WordClass) AssignId() {
 }
 
 func (this *WordClass) Marshal() {
-    // Remove sentence references until under limit
-    for _, info := range this.Info {
-        for len(info.Sref) > 200 {
-            n := rand.Intn(len(info.Sref))
-            narr := make([]*SentenceBref, len(info.Sref) - 1)
-            copy(narr, info.Sref[0:n])
-            copy(narr[n:], info.Sref[n + 1:])
-            info.Sref = narr
-        }
-    }
-    
     // Marshal data
     offset := 0
     for i, info := range this.Info {
@@ -313,15 +365,21 @@ func (this *WordClass) Marshal() {
         buf := bytes.NewBuffer(nil)
         
         binary.Write(buf, g_bo, uint16(len(info.Kele)))
-        for _, str := range info.Kele {
+        for k, str := range info.Kele {
             binary.Write(buf, g_bo, uint16(len(str)))
             buf.WriteString(str)
+            binary.Write(buf, g_bo, uint16(info.Kflag[k]))
         }
         
         binary.Write(buf, g_bo, uint16(len(info.Rele)))
-        for _, str := range info.Rele {
+        for r, str := range info.Rele {
             binary.Write(buf, g_bo, uint16(len(str)))
             buf.WriteString(str)
+            binary.Write(buf, g_bo, uint16(info.Rflag[r]))
+            binary.Write(buf, g_bo, uint16(len(info.Rrestr[r])))
+            for _, kref := range info.Rrestr[r] {
+                binary.Write(buf, g_bo, uint16(kref))
+            }
         }
         
         binary.Write(buf, g_bo, uint16(len(info.Sense)))
@@ -331,6 +389,18 @@ func (this *WordClass) Marshal() {
                 binary.Write(buf, g_bo, uint16(len(str)))
                 buf.WriteString(str)
             }
+            
+            stagk := word_refs(info.Kele, sense.Stagk)
+            binary.Write(buf, g_bo, uint16(len(stagk)))
+            for _, kref := range stagk {
+                binary.Write(buf, g_bo, uint16(kref))
+            }
+            
+            stagr := word_refs(info.Rele, sense.Stagr)
+            binary.Write(buf, g_bo, uint16(len(stagr)))
+            for _, rref := range stagr {
+                binary.Write(buf, g_bo, uint16(rref))
+            }
         }
         
         binary.Write(buf, g_bo, uint16(len(info.Cref)))
@@ -343,8 +413,12 @@ func (this *WordClass) Marshal() {
             binary.Write(buf, g_bo, uint32(sref.Info.Id))
             binary.Write(buf, g_bo, uint16(sref.Start))
             binary.Write(buf, g_bo, uint16(sref.End))
+            binary.Write(buf, g_bo, uint16(sref.Index))
         }
         
+        binary.Write(buf, g_bo, uint32(info.Freq))
+        binary.Write(buf, g_bo, uint32(info.Rank))
+        
         this.Info[i].Marshal = buf.Bytes()
         offset += len(this.Info[i].Marshal)
     }
@@ -397,6 +471,7 @@ func (this *WordClass) Load(fn string) bool {
     }
     
     // Parse entries
+    missing := map[string]int{}
     for _, entry := range save.Entry {
         // Categories
         cref := []*CategoryInfo{}
@@ -404,6 +479,8 @@ func (this *WordClass) Load(fn string) bool {
             _, exists := g_category.Label[cat]
             if exists {
                 cref = append(cref, g_category.Label[cat])
+            } else {
+                missing[cat] += 1
             }
         }
     
@@ -413,21 +490,57 @@ func (this *WordClass) Load(fn string) bool {
         // Entry
         info := &WordInfo{
             Ident: ident,
-            Kele: entry.Kele,
-            Rele: entry.Rele,
+            Kele: []string{},
+            Kflag: []int{},
+            Rele: []string{},
+            Rflag: []int{},
+            Rrestr: [][]int{},
             Sense: entry.Sense,
             Cref: cref,
             Sref: []*SentenceBref{},
         }
         this.Info = append(this.Info, info)
+        for _, sense := range entry.Sense { info.Deinf |= deinf_pos(sense.Pos) }
+        if len(entry.Sense) > 0 {
+            for _, str := range strings.Split(entry.Sense[0].Misc, ";") {
+                if str == "uk" { info.Kana = true }
+            }
+        }
+        for _, c := range cref {
+            if len(c.Label) == 2 && c.Label[0] == 'n' && c.Label[1] >= '1' && c.Label[1] <= '5' {
+                level := int(c.Label[1] - '0')
+                if level > info.Jlpt { info.Jlpt = level }
+            }
+        }
+        
+        // Spellings and readings with restrictions
+        for _, kele := range entry.Kele {
+            info.Kele = append(info.Kele, kele.Text)
+            info.Kflag = append(info.Kflag, kdb.WordFlags(strings.Split(kele.Inf, ";")))
+        }
+        for _, rele := range entry.Rele {
+            flags := kdb.WordFlags(strings.Split(rele.Inf, ";"))
+            if rele.Nokanji { flags |= kdb.WordFlagNokanji }
+            restr := []int{}
+            if len(rele.Restr) > 0 { restr = word_refs(info.Kele, strings.Split(rele.Restr, ";")) }
+            info.Rele = append(info.Rele, rele.Text)
+            info.Rflag = append(info.Rflag, flags)
+            info.Rrestr = append(info.Rrestr, restr)
+        }
         
         // Save category references
         for _, c := range cref {
             c.Words = append(c.Words, info)
         }
         
+        // Easiest level of each spelling
+        for _, str := range append(append([]string{}, info.Kele...), info.Rele...) {
+            level, exists := this.Level[str]
+            if !exists || info.Jlpt > level { this.Level[str] = info.Jlpt }
+        }
+        
         // Kanji and kana references
-        for _, str := range entry.Kele {
+        for _, str := range info.Kele {
             _, exists := this.BaseReal[str]
             if exists {
                 this.BaseReal[str] = append(this.BaseReal[str], WordRank{ Info: info, Rank: 0 })
@@ -435,7 +548,7 @@ func (this *WordClass) Load(fn string) bool {
                 this.BaseReal[str] = []WordRank{ WordRank{ Info: info, Rank: 0 } }
             }
         }
-        for _, str := range entry.Rele {
+        for _, str := range info.Rele {
             _, exists := this.BaseKana[str]
             if exists {
                 this.BaseKana[str] = append(this.BaseKana[str], WordRank{ Info: info, Rank: 0 })
@@ -462,6 +575,11 @@ func (this *WordClass) Load(fn string) bool {
         }
     }
     
+    // Labels without a category
+    for cat, num := range missing {
+        fmt.Printf("Warning: No category defined for label '%s' (%d words)\n", cat, num)
+    }
+    
     // Success
     return true
 }
@@ -510,130 +628,300 @@ func (this *WordClass) Save(fn string) {
 }
 
 
-/*
-func (this *WordClass) Search() {
-    
-    num_cpu := 4
-    runtime.GOMAXPROCS(num_cpu + 1)
-    
-    sem := make(chan int, num_cpu)
-    
-    num_unit := (len(this.Info) + num_cpu - 1) / num_cpu
-    
-    for i_cpu := 0; i_cpu < num_cpu; i_cpu++ {
-        num := num_unit
-        if (i_cpu * num_unit) + num > len(this.Info) { num = len(this.Info) - (i_cpu * num_unit) }
-        
-        go func(offset int, num int) {
-            for i := 0; i < num; i++ {
-                if i % 1000 == 0 { fmt.Printf("%dk ", i / 1000) }
-                this.SearchInfo(this.Info[offset + i])
+func (this *WordClass) Search(ctx context.Context, workers int) error {
+    // Word indices to workers, results back with their index
+    type SearchResult struct {
+        Index int
+        Sref []*SentenceBref
+    }
+    jobs := make(chan int, workers * 16)
+    done := make(chan SearchResult, workers * 16)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for n := range jobs {
+                select {
+                    case done <- SearchResult{ Index: n, Sref: this.SearchInfo(this.Info[n]) }:
+                    case <-ctx.Done(): return
+                }
             }
-            sem <- 1
-        } (i_cpu * num_unit, num)
+        } ()
     }
     
-    for i := 0; i < num_cpu; i++ {
-        <-sem
+    // Feed until cancelled
+    go func() {
+        defer close(jobs)
+        for n := range this.Info {
+            select {
+                case jobs <- n:
+                case <-ctx.Done(): return
+            }
+        }
+    } ()
+    go func() {
+        wg.Wait()
+        close(done)
+    } ()
+    
+    // Collect by word index, progress on one line
+    result := make([][]*SentenceBref, len(this.Info))
+    start := time.Now()
+    shown := start
+    num := 0
+    for item := range done {
+        result[item.Index] = item.Sref
+        num += 1
+        if now := time.Now(); now.Sub(shown) > 200 * time.Millisecond || num == len(this.Info) {
+            shown = now
+            eta := time.Duration(float64(now.Sub(start)) / float64(num) * float64(len(this.Info) - num))
+            fmt.Printf("\r%d/%d words (%.1f%%), ETA %s   ", num, len(this.Info), 100.0 * float64(num) / float64(len(this.Info)), eta.Round(time.Second))
+        }
     }
     fmt.Printf("\n")
+    if ctx.Err() != nil { return ctx.Err() }
+    
+    // Results in word order
+    for n, info := range this.Info {
+        info.Sref = result[n]
+    }
+    return nil
 }
-*/
 
-func (this *WordClass) Search() {
-    
-    num_cpu := 4
-    runtime.GOMAXPROCS(num_cpu + 1)
-    
-    sem := make(chan int, len(this.Info))
+// Words ignored in gloss and translation overlap
+var g_sense_stop = map[string]bool{
+    "the": true, "and": true, "for": true, "one": true, "with": true,
+    "something": true, "someone": true, "etc": true, "from": true,
+}
+
+// Sense index of sentences with no gloss overlap
+const SenseUnknown = 0xffff
+
+func sense_words(str string) map[string]bool {
+    ret := map[string]bool{}
+    for _, item := range strings.FieldsFunc(strings.ToLower(str), func(r rune) bool {
+        return !unicode.IsLetter(r)
+    }) {
+        if len(item) <= 2 { continue }
+        _, stop := g_sense_stop[item]
+        if stop { continue }
+        
+        ret[sense_stem(item)] = true
+    }
+    return ret
+}
+
+// Same stem for plain inflections of gloss and translation words
+func sense_stem(item string) string {
+    // Inflection endings
+    for _, suffix := range []string{ "ies", "ied", "ing", "ed", "es", "s" } {
+        if len(item) >= len(suffix) + 2 && strings.HasSuffix(item, suffix) {
+            item = item[0:len(item) - len(suffix)]
+            if suffix == "ies" || suffix == "ied" { item += "y" }
+            break
+        }
+    }
     
+    // Doubled consonant and silent e of the base form
+    n := len(item)
+    if n > 3 && item[n - 1] == item[n - 2] && !strings.ContainsRune("aeiou", rune(item[n - 1])) { item = item[0:n - 1] }
+    if len(item) > 2 && strings.HasSuffix(item, "e") { item = item[0:len(item) - 1] }
+    return item
+}
+
+// Meaning used in each sentence, curated sense number first and gloss overlap with translation otherwise
+func (this *WordClass) SenseAssign() {
     for _, info := range this.Info {
-        go func(info *WordInfo) {
-            this.SearchInfo(info)
-            sem <- 1
-        } (info)
-    }
-    
-    for i := 0; i < len(this.Info); i++ {
-        /*
-        name := ""
-        for _, kele := range this.Info[i].Kele {
-            if len(name) > 0 { name += ", " }
-            name += kele
+        // Gloss words of each sense
+        gloss := []map[string]bool{}
+        for _, sense := range info.Sense {
+            gloss = append(gloss, sense_words(strings.Join(sense.Gloss, " ")))
+        }
+        
+        for _, sref := range info.Sref {
+            if sref.Link && sref.Sense > 0 && sref.Sense <= len(info.Sense) {
+                sref.Index = sref.Sense - 1
+                continue
+            }
+            if len(info.Sense) == 1 {
+                sref.Index = 0
+                continue
+            }
+            sref.Index = SenseUnknown
+            best := 0
+            for s, words := range gloss {
+                score := 0
+                for item, _ := range words {
+                    if sref.Info.EnWords[item] { score++ }
+                }
+                if score > best {
+                    best = score
+                    sref.Index = s
+                }
+            }
         }
-        fmt.Printf("%d: %s\n", i, name)
-        */
-        //if i % 1000 == 0 { fmt.Printf("%dk ", i / 1000) }
-        <-sem
     }
-    fmt.Printf("\n")
 }
 
-func (this *WordClass) SearchInfo(info *WordInfo) {
-    name := ""
-    for _, kele := range info.Kele {
-        if len(name) > 0 { name += ", " }
-        name += kele
+// Example sentence lengths without penalty
+const g_select_short = 6
+const g_select_long = 25
+
+// Example value of a sentence for the word
+func (this *WordClass) SrefScore(info *WordInfo, sref *SentenceBref) int {
+    score := 0
+    
+    // Curated examples
+    if sref.Good { score += 30 }
+    if sref.Link { score += 10 }
+    
+    // Length
+    n := mark_rune_len(sref.Info.JpReal)
+    dist := 0
+    if n < g_select_short { dist = g_select_short - n }
+    if n > g_select_long { dist = n - g_select_long }
+    if dist < 10 { score += 20 - 2 * dist }
+    
+    // Vocabulary at or below the level of the word, words outside JLPT lists only for those outside too
+    target := info.Jlpt
+    if target == 0 { target = 1 }
+    known := 0
+    total := 0
+    for _, base := range sref.Info.Base {
+        level, exists := this.Level[base]
+        if !exists || sentence_kana(base) { continue }
+        total += 1
+        if level >= target || (info.Jlpt == 0 && level == 0) { known += 1 }
     }
-    fmt.Printf("%d: %s\n", info.Ident, name)
+    if total > 0 { score += 30 * known / total }
+    
+    // Furigana and quality filter
+    score += sref.Info.Quality / 5
+    
+    // Sentences harder than the word, unknown level 0 included
+    if info.Jlpt > 0 && sref.Info.Level < info.Jlpt { score -= 10 * (info.Jlpt - sref.Info.Level) }
+    score -= sref.Info.Difficulty / 10
+    return score
+}
 
-    // Try kanji match
-    for _, kele := range info.Kele {
-        list, exists := g_sentence.BaseReal[kele]
-        if exists {
-            for _, item := range list {
-                found := false
-                for _, sref := range info.Sref {
-                    if sref.Info == item.Info { found = true }
-                }
-                if !found { info.Sref = append(info.Sref, item) }
+type SentenceBrefScore struct {
+    List []*SentenceBref
+    Score map[*SentenceBref]int
+}
+func (this SentenceBrefScore) Len() int { return len(this.List) }
+func (this SentenceBrefScore) Swap(i, j int) { this.List[i], this.List[j] = this.List[j], this.List[i] }
+func (this SentenceBrefScore) Less(i, j int) bool {
+    a := this.List[i]
+    b := this.List[j]
+    if this.Score[a] != this.Score[b] { return this.Score[a] > this.Score[b] }
+    return a.Info.Before(b.Info)
+}
+
+// Rank sentence references and keep the best ones within budget
+func (this *WordClass) Select(info *WordInfo, budget int) {
+    rank := SentenceBrefScore{ List: info.Sref, Score: map[*SentenceBref]int{} }
+    for _, sref := range info.Sref { rank.Score[sref] = this.SrefScore(info, sref) }
+    sort.Sort(rank)
+    if len(info.Sref) > budget { info.Sref = info.Sref[0:budget] }
+}
+
+func sentence_kana(str string) bool {
+    for _, r := range str {
+        if !unicode.In(r, unicode.Hiragana) { return false }
+    }
+    return true
+}
+
+// Corpus link headwords, readings only when the word is written in kana
+func (this *WordInfo) Heads() []string {
+    if len(this.Kele) > 0 && !this.Kana { return this.Kele }
+    return append(append([]string{}, this.Kele...), this.Rele...)
+}
+
+func (this *WordClass) SearchInfo(info *WordInfo) []*SentenceBref {
+    // Sentences already referenced
+    list := []*SentenceBref{}
+    found := map[*SentenceInfo]bool{}
+    add := func(item *SentenceBref) {
+        if found[item.Info] { return }
+        found[item.Info] = true
+        sref := *item
+        list = append(list, &sref)
+    }
+    
+    // Hand-checked corpus links, readings must agree when given
+    for _, head := range info.Heads() {
+        for _, item := range g_sentence.Link[head] {
+            valid := len(item.Kana) == 0
+            for _, rele := range info.Rele {
+                if rele == item.Kana { valid = true }
             }
+            if valid { add(item) }
         }
     }
-    if len(info.Sref) > 3 {
-        return
+    if len(list) > 3 {
+        return list
+    }
+
+    // Try kanji match
+    for _, kele := range info.Kele {
+        for _, item := range g_sentence.BaseReal[kele] { add(item) }
+    }
+    if len(list) > 3 {
+        return list
     }
     
     // Try kana match
     for _, rele := range info.Rele {
-        list, exists := g_sentence.BaseKana[rele]
-        if exists {
-            for _, item := range list {
-                found := false
-                for _, sref := range info.Sref {
-                    if sref.Info == item.Info { found = true }
-                }
-                if !found { info.Sref = append(info.Sref, item) }
-            }
-        }
+        for _, item := range g_sentence.BaseKana[rele] { add(item) }
     }
-    if len(info.Sref) > 3 {
-        return
+    if len(list) > 3 {
+        return list
     }
     
-    // Try kanji full-text search
-    for _, kele := range info.Kele {
-        list := g_sentence.Search(kele)
-        for _, item := range list {
-            found := false
-            for _, sref := range info.Sref {
-                if sref.Info == item.Info { found = true }
-            }
-            if !found { info.Sref = append(info.Sref, item) }
+    // Try inflected forms, kana-only words by their readings
+    if info.Deinf != 0 {
+        spelling := info.Kele
+        if len(spelling) == 0 { spelling = info.Rele }
+        for _, str := range spelling {
+            for _, item := range g_sentence.SearchInflected(str, info.Deinf, len(info.Kele) == 0) { add(item) }
+        }
+        if len(list) > 3 {
+            return list
         }
     }
-    if len(info.Sref) > 3 {
-        return
+    
+    // Try kanji full-text search
+    for _, kele := range info.Kele {
+        for _, item := range g_sentence.Search(kele) { add(item) }
     }
+    return list
 }
 
 // <===> Sentences <===========================================================>
 type SentenceInfo struct {
     // Info
+    Source *SourceInfo
     Ident int
+    // Other sources with the same sentence
+    Also []*SourceInfo
     JpReal string
     JpKana string
     En string
+    EnWords map[string]bool
+    // Base forms of the words in sentence, analyzer tokens and corpus links
+    Base []string
+    Token []SentenceToken
+    // Quality filter score, 0 to 100
+    Quality int
+    // Hardest JLPT level 5 to 1 or 0 when unknown, words and kanji outside the lists and combined difficulty
+    Level int
+    Outside int
+    Unlisted int
+    Difficulty int
+    // Word references
+    Usage int
     // Marshal
     Id int
     Offset int
@@ -643,7 +931,7 @@ type SentenceInfo struct {
 type SentenceInfoIdent []*SentenceInfo
 func (list SentenceInfoIdent) Len() int { return len(list) }
 func (list SentenceInfoIdent) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
-func (list SentenceInfoIdent) Less(i, j int) bool { return list[i].Ident < list[j].Ident }
+func (list SentenceInfoIdent) Less(i, j int) bool { return list[i].Before(list[j]) }
 
 type SentenceIndex struct {
     Name string
@@ -655,6 +943,15 @@ type SentenceBref struct {
     Info *SentenceInfo
     Start int
     End int
+    // Corpus link, sense number from one
+    Link bool
+    Kana string
+    Sense int
+    Good bool
+    // Sense index of the word used in sentence
+    Index int
+    // Found as inflected form
+    Inflected bool
 }
 
 type SentenceClass struct {
@@ -662,6 +959,13 @@ type SentenceClass struct {
     Info SentenceInfoIdent
     BaseReal map[string][]*SentenceBref
     BaseKana map[string][]*SentenceBref
+    Link map[string][]*SentenceBref
+    // Sentences by text for merging sources
+    Key map[string]*SentenceInfo
+    // Full-text index, byte offset of each sentence in the indexed text
+    Text *suffixarray.Index
+    TextStart []int
+    TextInfo []*SentenceInfo
     // Index
     Index []*SentenceIndex
     // Data
@@ -675,6 +979,8 @@ func SentenceNew() *SentenceClass {
         Info: []*SentenceInfo{},
         BaseReal: map[string][]*SentenceBref{},
         BaseKana: map[string][]*SentenceBref{},
+        Link: map[string][]*SentenceBref{},
+        Key: map[string]*SentenceInfo{},
         // Indices
         Index: []*SentenceIndex{},
     }
@@ -683,25 +989,93 @@ func SentenceNew() *SentenceClass {
     return this
 }
 
+func (this *SentenceClass) IndexText() {
+    // Sentences separated by zero bytes so matches stay within one sentence
+    buf := bytes.NewBuffer(nil)
+    this.TextStart = make([]int, len(this.Info))
+    this.TextInfo = make([]*SentenceInfo, len(this.Info))
+    for i, info := range this.Info {
+        this.TextStart[i] = buf.Len()
+        this.TextInfo[i] = info
+        buf.WriteString(info.JpReal)
+        buf.WriteByte(0)
+    }
+    this.Text = suffixarray.New(buf.Bytes())
+}
+
 func (this *SentenceClass) Search(text string) []*SentenceBref {
     list := []*SentenceBref{}
+    if len(text) == 0 { return list }
     mark_size := mark_rune_len(text)
-    for _, info := range this.Info {
-        index := strings.Index(info.JpReal, text)
-        if index >= 0 {
-            mark_start := mark_rune_len(info.JpReal[0:index])
-            sref := &SentenceBref{
-                Info: info,
-                Start: mark_start,
-                End: mark_start + mark_size,
+    
+    // Matches in sentence order, first one of each sentence
+    pos := this.Text.Lookup([]byte(text), -1)
+    sort.Ints(pos)
+    last := -1
+    for _, index := range pos {
+        n := sort.SearchInts(this.TextStart, index + 1) - 1
+        if n == last { continue }
+        last = n
+        info := this.TextInfo[n]
+        mark_start := mark_rune_len(info.JpReal[0:index - this.TextStart[n]])
+        sref := &SentenceBref{
+            Info: info,
+            Start: mark_start,
+            End: mark_start + mark_size,
+        }
+        list = append(list, sref)
+    }
+    return list
+}
+
+// Longest inflected form of the word after an occurrence of its stem
+// Every inflected occurrence of the word, a sentence can have several
+func (this *SentenceClass) SearchInflected(word string, types int, kana bool) []*SentenceBref {
+    list := []*SentenceBref{}
+    found := map[int]bool{}
+    for _, stem := range deinf_stem(word, types) {
+        // Short kana stems appear everywhere
+        if kana && mark_rune_len(stem) < 2 { continue }
+        
+        pos := this.Text.Lookup([]byte(stem), -1)
+        sort.Ints(pos)
+        for _, index := range pos {
+            n := sort.SearchInts(this.TextStart, index + 1) - 1
+            info := this.TextInfo[n]
+            if found[index] { continue }
+            
+            // Continuations after the stem, longest first
+            offset := index - this.TextStart[n]
+            rest := []rune(info.JpReal[offset + len(stem):])
+            if len(rest) > g_deinf_max { rest = rest[0:g_deinf_max] }
+            for k := len(rest); k >= 0; k-- {
+                // Dictionary form is found as a base form and is not inflected
+                surface := stem + string(rest[0:k])
+                if surface == word { break }
+                if !deinf_match(surface, word, types) { continue }
+                mark_start := mark_rune_len(info.JpReal[0:offset])
+                list = append(list, &SentenceBref{
+                    Info: info,
+                    Start: mark_start,
+                    End: mark_start + mark_rune_len(surface),
+                    Inflected: true,
+                })
+                found[index] = true
+                break
             }
-            list = append(list, sref)
         }
     }
     return list
 }
 
 func (this *SentenceClass) AssignId() {
+    // Drop sentences no word refers to
+    list := SentenceInfoIdent{}
+    for _, info := range this.Info {
+        if info.Usage > 0 { list = append(list, info) }
+    }
+    this.Info = list
+    
     // Sort
     sort.Sort(this.Info)
     
@@ -726,6 +1100,18 @@ func (this *SentenceClass) Marshal() {
         binary.Write(buf, g_bo, uint16(len(info.En)))
         buf.WriteString(info.En)
         
+        binary.Write(buf, g_bo, uint16(info.Level))
+        binary.Write(buf, g_bo, uint16(info.Outside))
+        binary.Write(buf, g_bo, uint16(info.Unlisted))
+        binary.Write(buf, g_bo, uint16(info.Difficulty))
+        
+        binary.Write(buf, g_bo, uint16(info.Source.Id))
+        binary.Write(buf, g_bo, uint32(info.Ident))
+        binary.Write(buf, g_bo, uint16(len(info.Also)))
+        for _, also := range info.Also {
+            binary.Write(buf, g_bo, uint16(also.Id))
+        }
+        
         this.Info[i].Marshal = buf.Bytes()
         offset += len(this.Info[i].Marshal)
     }
@@ -751,13 +1137,14 @@ func (this *SentenceClass) Marshal() {
     this.Data = buf.Bytes()
 }
 
-func (this *SentenceClass) Load(fn string) bool {
+func (this *SentenceClass) Load(source *SourceInfo) bool {
     // File
-    fs, err := os.Open(fn)
+    fs, err := os.Open(source.File)
     if (err != nil) {
-        fmt.Printf("Failed to open file: " + err.Error() + "\n")
+        fmt.Printf("Failed to open file: %s\n", err.Error())
         return false
     }
+    defer fs.Close()
     
     // Line reader
     reader := bufio.NewReader(fs)
@@ -781,15 +1168,37 @@ func (this *SentenceClass) Load(fn string) bool {
         jp_kana := strings.TrimSpace(record[2])
         en := strings.TrimSpace(record[3])
         
-        // Info
-        info := &SentenceInfo{
-            // Info
-            Ident: jp_ident,
-            JpReal: jp_real,
-            JpKana: jp_kana,
-            En: en,
+        // Furigana from the span column
+        if (len(record) >= 8) {
+            spans := furigana.Parse(strings.TrimSpace(record[7]))
+            if (len(spans) > 0) { jp_kana = spans.Format(g_furigana) }
+        }
+        quality := 100
+        if (len(record) >= 10) { quality, _ = strconv.Atoi(strings.TrimSpace(record[9])) }
+        
+        // Sentence from a source of higher priority keeps its text and
+        // translation, words of the duplicate are added to it when the
+        // offsets agree
+        key := source_key(jp_real)
+        info, merge := this.Key[key]
+        if merge && info.Source != source {
+            if !info.From(source) { info.Also = append(info.Also, source) }
+            if info.JpReal != jp_real { continue }
+        } else {
+            merge = false
+            info = &SentenceInfo{
+                // Info
+                Source: source,
+                Ident: jp_ident,
+                JpReal: jp_real,
+                JpKana: jp_kana,
+                En: en,
+                EnWords: sense_words(en),
+                Quality: quality,
+            }
+            this.Info = append(this.Info, info)
+            if _, exists := this.Key[key]; !exists { this.Key[key] = info }
         }
-        this.Info = append(this.Info, info)
         
         // Base kanji and hiragana
         blist := strings.Split(strings.TrimSpace(record[4]), ";")
@@ -801,6 +1210,10 @@ func (this *SentenceClass) Load(fn string) bool {
             // Format mark for base
             start, _ := strconv.Atoi(strings.TrimSpace(dlist[2]))
             end, _ := strconv.Atoi(strings.TrimSpace(dlist[3]))
+            token := SentenceToken{ Start: start, End: end, Real: dlist[0], Kana: dlist[1] }
+            if merge && info.HasToken(token) { continue }
+            info.Base = append(info.Base, dlist[0])
+            info.Token = append(info.Token, token)
             
             // Insert base kanji
             _, bvalid := this.BaseReal[dlist[0]]
@@ -852,6 +1265,34 @@ func (this *SentenceClass) Load(fn string) bool {
                     }}
             }
         }
+        
+        // Curated corpus links
+        if (len(record) < 6) { continue }
+        llist := strings.Split(strings.TrimSpace(record[5]), ";")
+        for _, litem := range llist {
+            // Check validity
+            dlist := strings.Split(litem, "@")
+            if (len(dlist) < 6) { continue }
+            
+            // Values
+            sense, _ := strconv.Atoi(strings.TrimSpace(dlist[2]))
+            start, _ := strconv.Atoi(strings.TrimSpace(dlist[3]))
+            end, _ := strconv.Atoi(strings.TrimSpace(dlist[4]))
+            
+            // Insert headword
+            token := SentenceToken{ Start: start, End: end, Real: dlist[0], Kana: dlist[1], Link: true }
+            if merge && info.HasToken(token) { continue }
+            info.Token = append(info.Token, token)
+            this.Link[dlist[0]] = append(this.Link[dlist[0]], &SentenceBref{
+                Info: info,
+                Start: start,
+                End: end,
+                Link: true,
+                Kana: dlist[1],
+                Sense: sense,
+                Good: strings.TrimSpace(dlist[5]) == "1",
+            })
+        }
     }
     
     // Success
@@ -1040,9 +1481,35 @@ func rune_decode(str string) []rune {
 var g_category *CategoryClass
 var g_word *WordClass
 var g_sentence *SentenceClass
+var g_furigana string
+var g_examples int
+var g_workers int
 
 // Main function
 func main() {
+    // Arguments
+    workers := flag.Int("workers", runtime.NumCPU(), "Number of sentence search workers")
+    fn_sources := flag.String("sources", "sources.pipe", "Sentence source list")
+    examples := flag.Int("examples", 200, "Example sentences kept for each word")
+    budget_sentences := flag.Int("budget-sentences", 0, "Sentence budget of the global selection, 0 for no limit")
+    budget_bytes := flag.Int("budget-bytes", 0, "Sentence data size budget of the global selection, 0 for no limit")
+    cover_min := flag.Int("min-examples", 3, "Examples a word needs to count as covered")
+    fmt_furigana := flag.String("furigana", "kotoba", "Sentence furigana format (" + strings.Join(furigana.Formats, ", ") + ")")
+    flag.Parse()
+    g_furigana = *fmt_furigana
+    g_examples = *examples
+    g_workers = *workers
+    if (g_workers < 1) { g_workers = 1 }
+    g_cover_min = *cover_min
+    found := false
+    for _, item := range furigana.Formats {
+        if (item == g_furigana) { found = true }
+    }
+    if (!found) {
+        fmt.Printf("Unknown furigana format '%s'!\n", g_furigana)
+        return
+    }
+    
     // Byte order
     g_bo = binary.LittleEndian
     
@@ -1053,7 +1520,7 @@ func main() {
 
     // Load
     fmt.Print("Loading categories...\n")
-    if (!g_category.Load()) {
+    if (!g_category.Load("categories.pipe")) {
         fmt.Printf("Error parsing categories!\n")
         return
     }
@@ -1063,14 +1530,53 @@ func main() {
         return
     }
     fmt.Print("Loading sentences...\n")
-    if (!g_sentence.Load("sentences.pipe")) {
-        fmt.Printf("Error reading sentences file!\n")
-        return
+    sources := SourceLoad(*fn_sources)
+    for _, source := range sources {
+        num := len(g_sentence.Info)
+        if (!g_sentence.Load(source)) {
+            fmt.Printf("Error reading sentences file!\n")
+            return
+        }
+        fmt.Printf("%s: %d sentences\n", source.Name, len(g_sentence.Info) - num)
     }
+    for _, source := range sources {
+        num := 0
+        for _, info := range g_sentence.Info {
+            for _, also := range info.Also {
+                if also == source { num++ }
+            }
+        }
+        if num > 0 { fmt.Printf("%s: %d duplicates merged\n", source.Name, num) }
+    }
+    g_sentence.IndexText()
     
-    // Sentence search
+    // Sentence search, interrupt cancels
     fmt.Print("Sentence search...\n")
-    g_word.Search()
+    deinf_init()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    err := g_word.Search(ctx, g_workers)
+    stop()
+    if (err != nil) {
+        fmt.Printf("Sentence search cancelled: %s\n", err.Error())
+        return
+    }
+    g_word.SenseAssign()
+    g_word.Frequency()
+    
+    // Sentence difficulty
+    fmt.Printf("Grading sentences...\n")
+    g_sentence.Grade(g_word)
+    
+    // Example selection
+    fmt.Printf("Selecting examples...\n")
+    for _, info := range g_word.Info {
+        g_word.Select(info, g_examples)
+    }
+    if (*budget_sentences > 0 || *budget_bytes > 0) {
+        g_word.Cover(*budget_sentences, *budget_bytes)
+    }
+    g_word.Usage()
+    g_word.CoverReport("out-coverage.txt")
     
     // Id generation
     fmt.Printf("Assigning ids...\n")
@@ -1089,6 +1595,9 @@ func main() {
     g_category.Save("kotoba-category.kdb")
     g_word.Save("kotoba-word.kdb")
     g_sentence.Save("kotoba-sentence.kdb")
+    g_sentence.GradeSave("kotoba-sentence-category.kdb")
+    g_sentence.TokenSave("kotoba-sentence-token.kdb", g_word)
+    SourceSave("kotoba-source.kdb", sources)
     
     // Bases
     fmt.Print("Generating bases...\n")
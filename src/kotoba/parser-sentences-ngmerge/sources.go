@@ -0,0 +1,152 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "bufio"
+    "bytes"
+    "sort"
+    "strings"
+    "strconv"
+    "encoding/binary"
+)
+
+// <===> Sentence sources <====================================================>
+// Sentence corpus, higher priority wins duplicates
+type SourceInfo struct {
+    Name string
+    File string
+    Priority int
+    License string
+    // Order in config
+    Order int
+    // Marshal
+    Id int
+}
+
+type SourceInfoPriority []*SourceInfo
+func (list SourceInfoPriority) Len() int { return len(list) }
+func (list SourceInfoPriority) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list SourceInfoPriority) Less(i, j int) bool {
+    if list[i].Priority != list[j].Priority { return list[i].Priority > list[j].Priority }
+    return list[i].Order < list[j].Order
+}
+
+// Tanaka corpus alone when there is no source list
+var g_source_default = &SourceInfo{
+    Name: "Tanaka Corpus",
+    File: "sentences.pipe",
+    License: "CC-BY 2.0 FR",
+}
+
+func SourceLoad(fn string) []*SourceInfo {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("No sentence source list, using %s\n", g_source_default.File)
+        return []*SourceInfo{ g_source_default }
+    }
+    defer fs.Close()
+    
+    // Line reader, "name<tab>file<tab>priority<tab>license" per line
+    list := SourceInfoPriority{}
+    reader := bufio.NewReader(fs)
+    for err == nil {
+        // Read line
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil && len(line) == 0) { break }
+        
+        // Skip comments and empty lines
+        if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") { continue }
+        
+        // Split
+        record := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+        if (len(record) < 4) {
+            fmt.Printf("Error: Line does not have enough columns! num=%d\n", len(record))
+            continue
+        }
+        priority, _ := strconv.Atoi(strings.TrimSpace(record[2]))
+        list = append(list, &SourceInfo{
+            Name: strings.TrimSpace(record[0]),
+            File: strings.TrimSpace(record[1]),
+            Priority: priority,
+            License: strings.TrimSpace(record[3]),
+            Order: len(list),
+        })
+    }
+    
+    // Ids by priority
+    sort.Sort(list)
+    for i, info := range list { info.Id = i }
+    return list
+}
+
+// Sentence text compared between sources
+func source_key(str string) string {
+    return strings.Join(strings.Fields(str), "")
+}
+
+// Source has the sentence
+func (this *SentenceInfo) From(source *SourceInfo) bool {
+    if this.Source == source { return true }
+    for _, item := range this.Also {
+        if item == source { return true }
+    }
+    return false
+}
+
+// Token already given by a source of higher priority
+func (this *SentenceInfo) HasToken(token SentenceToken) bool {
+    for _, item := range this.Token {
+        if item == token { return true }
+    }
+    return false
+}
+
+// Sentence order, source first and ident within it
+func (this *SentenceInfo) Before(other *SentenceInfo) bool {
+    if this.Source.Id != other.Source.Id { return this.Source.Id < other.Source.Id }
+    return this.Ident < other.Ident
+}
+
+// Names and licenses of the sources for attribution
+func SourceSave(fn string, list []*SourceInfo) {
+    // Marshal data
+    offset := 0
+    data := [][]byte{}
+    offsets := []int{}
+    for _, info := range list {
+        buf := bytes.NewBuffer(nil)
+        binary.Write(buf, g_bo, uint16(len(info.Name)))
+        buf.WriteString(info.Name)
+        binary.Write(buf, g_bo, uint16(len(info.License)))
+        buf.WriteString(info.License)
+        offsets = append(offsets, offset)
+        data = append(data, buf.Bytes())
+        offset += buf.Len()
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    binary.Write(buf, g_bo, uint32(len(list)))
+    for _, item := range offsets {
+        binary.Write(buf, g_bo, uint32(item))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    for _, item := range data {
+        buf.Write(item)
+    }
+    
+    // Save
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(buf.Bytes())
+    fs.Close()
+}
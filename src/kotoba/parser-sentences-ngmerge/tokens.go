@@ -0,0 +1,144 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "bytes"
+    "sort"
+    "unicode"
+    "encoding/binary"
+)
+
+// <===> Sentence tokens <=====================================================>
+// Word span in sentence, rune offsets
+type SentenceToken struct {
+    Start int
+    End int
+    Real string
+    Kana string
+    // Corpus link
+    Link bool
+}
+
+// How a span was resolved to words
+const (
+    TokenBase = iota
+    TokenKana
+    TokenLink
+)
+
+type TokenSpan struct {
+    Start int
+    End int
+    Kind int
+    Words []int
+}
+
+type TokenSpanStart []*TokenSpan
+func (list TokenSpanStart) Len() int { return len(list) }
+func (list TokenSpanStart) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list TokenSpanStart) Less(i, j int) bool {
+    if list[i].Start != list[j].Start { return list[i].Start < list[j].Start }
+    return list[i].End < list[j].End
+}
+
+func token_words(list []WordRank, kana string) []int {
+    ret := []int{}
+    seen := map[int]bool{}
+    for _, item := range list {
+        if item.Info.Id < 0 || seen[item.Info.Id] { continue }
+        if len(kana) > 0 {
+            valid := false
+            for _, rele := range item.Info.Rele {
+                if rele == kana { valid = true }
+            }
+            if !valid { continue }
+        }
+        seen[item.Info.Id] = true
+        ret = append(ret, item.Info.Id)
+    }
+    sort.Ints(ret)
+    return ret
+}
+
+// Base form without kanji, reading lookup would otherwise match homophones
+func token_kana(str string) bool {
+    for _, r := range str {
+        if unicode.Is(unicode.Han, r) { return false }
+    }
+    return true
+}
+
+// Spans of a sentence with their words, corpus links before analyzer tokens of the same range
+func (this *SentenceInfo) Spans(words *WordClass) []*TokenSpan {
+    ret := TokenSpanStart{}
+    done := map[[2]int]bool{}
+    for _, link := range []bool{ true, false } {
+        for _, token := range this.Token {
+            if token.Link != link || done[[2]int{ token.Start, token.End }] { continue }
+            span := &TokenSpan{ Start: token.Start, End: token.End }
+            if link {
+                span.Kind = TokenLink
+                span.Words = token_words(append(append([]WordRank{}, words.BaseReal[token.Real]...), words.BaseKana[token.Real]...), token.Kana)
+            } else {
+                span.Kind = TokenBase
+                span.Words = token_words(words.BaseReal[token.Real], "")
+                if len(span.Words) == 0 && token_kana(token.Real) {
+                    span.Kind = TokenKana
+                    span.Words = token_words(words.BaseKana[token.Kana], "")
+                }
+            }
+            if len(span.Words) == 0 { continue }
+            done[[2]int{ token.Start, token.End }] = true
+            ret = append(ret, span)
+        }
+    }
+    sort.Sort(ret)
+    return ret
+}
+
+// Spans and word ids of each sentence in sentence id order
+func (this *SentenceClass) TokenSave(fn string, words *WordClass) {
+    // Marshal data
+    offset := 0
+    data := [][]byte{}
+    offsets := []int{}
+    for _, info := range this.Info {
+        buf := bytes.NewBuffer(nil)
+        spans := info.Spans(words)
+        binary.Write(buf, g_bo, uint16(len(spans)))
+        for _, span := range spans {
+            binary.Write(buf, g_bo, uint16(span.Start))
+            binary.Write(buf, g_bo, uint16(span.End))
+            binary.Write(buf, g_bo, uint16(span.Kind))
+            binary.Write(buf, g_bo, uint16(len(span.Words)))
+            for _, id := range span.Words {
+                binary.Write(buf, g_bo, uint32(id))
+            }
+        }
+        offsets = append(offsets, offset)
+        data = append(data, buf.Bytes())
+        offset += buf.Len()
+    }
+    
+    // Data buffer
+    buf := bytes.NewBuffer(nil)
+    binary.Write(buf, g_bo, uint32(len(this.Info)))
+    for _, item := range offsets {
+        binary.Write(buf, g_bo, uint32(item))
+    }
+    binary.Write(buf, g_bo, uint32(offset))
+    for _, item := range data {
+        buf.Write(item)
+    }
+    
+    // Save
+    fs := DataOpen(fn)
+    if (fs == nil) { return }
+    fs.Write(buf.Bytes())
+    fs.Close()
+}
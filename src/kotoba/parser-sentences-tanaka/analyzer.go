@@ -0,0 +1,254 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "os/exec"
+    "io"
+    "bufio"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// <===> Analyzer <============================================================>
+// Morphological analysis result for one token, offsets are in bytes
+type Token struct {
+    Surface string
+    Reading string
+    Base string
+    Pos string
+    Start int
+    End int
+    // Not in the analyzer dictionary
+    Unknown bool
+}
+
+type Analyzer interface {
+    Analyze(str string) ([]Token, error)
+    Close()
+}
+
+func AnalyzerOpen(kind string) (Analyzer, error) {
+    switch kind {
+        case "mecab": return MecabOpen(g_mecab_fields)
+        case "table": return TableOpen(g_table)
+    }
+    return nil, fmt.Errorf("unknown analyzer '%s'", kind)
+}
+
+// <===> MeCab <===============================================================>
+// IPAdic feature positions of reading, base form and part of speech
+var g_mecab_fields = []int{ 7, 6, 0 }
+
+// Long-lived mecab process, one sentence per line in and out
+type MecabAnalyzer struct {
+    cmd *exec.Cmd
+    in io.WriteCloser
+    out *bufio.Reader
+    fields []int
+}
+
+func MecabOpen(fields []int) (*MecabAnalyzer, error) {
+    this := &MecabAnalyzer{ fields: fields }
+    err := this.open()
+    if err != nil { return nil, err }
+    return this, nil
+}
+
+func (this *MecabAnalyzer) open() error {
+    node := fmt.Sprintf("--node-format=%%ps|%%pe|%%m|%%f[%d]|%%f[%d]|%%f[%d]|0~", this.fields[0], this.fields[1], this.fields[2])
+    unk := fmt.Sprintf("--unk-format=%%ps|%%pe|%%m|%%m|%%m|%%f[%d]|1~", this.fields[2])
+    this.cmd = exec.Command("mecab", node, "--eos-format=\n", unk)
+    var err error
+    this.in, err = this.cmd.StdinPipe()
+    if err != nil { return err }
+    out, err := this.cmd.StdoutPipe()
+    if err != nil { return err }
+    this.out = bufio.NewReader(out)
+    return this.cmd.Start()
+}
+
+func (this *MecabAnalyzer) Close() {
+    if this.cmd == nil { return }
+    this.in.Close()
+    this.cmd.Wait()
+    this.cmd = nil
+}
+
+func (this *MecabAnalyzer) run(str string) (string, error) {
+    // Restart the process once if it went away
+    var err error
+    for retry := 0; retry < 2; retry++ {
+        if this.cmd == nil {
+            err = this.open()
+            if err != nil { return "", err }
+        }
+        _, err = io.WriteString(this.in, str + "\n")
+        if err == nil {
+            var line string
+            line, err = this.out.ReadString('\n')
+            if err == nil { return line, nil }
+        }
+        this.Close()
+    }
+    return "", err
+}
+
+func (this *MecabAnalyzer) Analyze(str string) ([]Token, error) {
+    // Input sanitization, one line per sentence
+    str = strings.Replace(str, "|", "", -1)
+    str = strings.Replace(str, "~", "", -1)
+    str = strings.Replace(str, "\r", " ", -1)
+    str = strings.Replace(str, "\n", " ", -1)
+    
+    // Execute
+    txt_full, err := this.run(str)
+    if err != nil { return nil, err }
+    
+    // Result parsing
+    txt_full = strings.TrimSpace(txt_full)
+    txt_full = strings.Trim(txt_full, "~")
+    ret := []Token{}
+    for _, item := range strings.Split(txt_full, "~") {
+        split := strings.Split(item, "|")
+        if (len(split) < 6) { continue }
+        token := Token{
+            Surface: split[2],
+            Reading: split[3],
+            Base: split[4],
+            Pos: split[5],
+            Unknown: len(split) > 6 && split[6] == "1",
+        }
+        fmt.Sscan(split[0], &token.Start)
+        fmt.Sscan(split[1], &token.End)
+        ret = append(ret, token)
+    }
+    return ret, nil
+}
+
+// <===> Table <===============================================================>
+// Deterministic stand-in analyzer working from canned analyses.
+//
+// Table file lines are "surface<tab>reading<tab>base<tab>pos". Lines before
+// the first "=sentence" line form a dictionary for greedy longest match, lines
+// after a "=sentence" line are the exact analysis of that sentence.
+type TableAnalyzer struct {
+    Word map[string]Token
+    Sentence map[string][]Token
+    Longest int
+}
+
+var g_table string
+var g_table_cache map[string]*TableAnalyzer = map[string]*TableAnalyzer{}
+
+func TableOpen(fn string) (*TableAnalyzer, error) {
+    // Shared between workers as tables are read only
+    this, exists := g_table_cache[fn]
+    if exists { return this, nil }
+    
+    // File
+    fs, err := os.Open(fn)
+    if err != nil { return nil, err }
+    defer fs.Close()
+    
+    // Line reader
+    this = &TableAnalyzer{
+        Word: map[string]Token{},
+        Sentence: map[string][]Token{},
+        Longest: 1,
+    }
+    sentence := ""
+    reader := bufio.NewReader(fs)
+    for err == nil {
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil && len(line) == 0) { break }
+        line = strings.TrimRight(line, "\r\n")
+        if len(line) == 0 || strings.HasPrefix(line, "#") { continue }
+        
+        // Sentence block
+        if strings.HasPrefix(line, "=") {
+            sentence = strings.TrimPrefix(line, "=")
+            this.Sentence[sentence] = []Token{}
+            continue
+        }
+        
+        // Token
+        record := strings.Split(line, "\t")
+        if len(record) < 4 {
+            fmt.Printf("Error: Table line does not have enough columns! line='%s'\n", line)
+            continue
+        }
+        token := Token{
+            Surface: record[0],
+            Reading: record[1],
+            Base: record[2],
+            Pos: record[3],
+            Unknown: record[3] == "unknown",
+        }
+        if sentence != "" {
+            this.Sentence[sentence] = append(this.Sentence[sentence], token)
+        } else {
+            this.Word[token.Surface] = token
+            if n := utf8.RuneCountInString(token.Surface); n > this.Longest { this.Longest = n }
+        }
+    }
+    
+    // Success
+    g_table_cache[fn] = this
+    return this, nil
+}
+
+func (this *TableAnalyzer) Close() {
+}
+
+func (this *TableAnalyzer) Analyze(str string) ([]Token, error) {
+    // Canned sentence
+    list, exists := this.Sentence[str]
+    if !exists { list = this.Match(str) }
+    
+    // Offsets of the surfaces in the input
+    ret := []Token{}
+    offset := 0
+    for _, token := range list {
+        idx := strings.Index(str[offset:], token.Surface)
+        if idx < 0 { return nil, fmt.Errorf("table token '%s' not in '%s'", token.Surface, str) }
+        token.Start = offset + idx
+        token.End = token.Start + len(token.Surface)
+        offset = token.End
+        ret = append(ret, token)
+    }
+    return ret, nil
+}
+
+func (this *TableAnalyzer) Match(str string) []Token {
+    // Greedy longest match with single character fallback
+    ret := []Token{}
+    text_r := []rune(str)
+    for len(text_r) > 0 {
+        if unicode.IsSpace(text_r[0]) {
+            text_r = text_r[1:]
+            continue
+        }
+        n := this.Longest
+        if n > len(text_r) { n = len(text_r) }
+        for ; n > 1; n-- {
+            if _, exists := this.Word[string(text_r[0:n])]; exists { break }
+        }
+        surface := string(text_r[0:n])
+        token, exists := this.Word[surface]
+        if !exists {
+            token = Token{ Surface: surface, Reading: surface, Base: surface, Pos: "unknown", Unknown: true }
+        }
+        ret = append(ret, token)
+        text_r = text_r[n:]
+    }
+    return ret
+}
@@ -0,0 +1,140 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "io"
+    "bufio"
+    "strings"
+    "strconv"
+)
+
+// <===> Checkpoint <==========================================================>
+// Progress of an interrupted run, output files are cut back to the flushed sizes on resume
+type Checkpoint struct {
+    // Run arguments, resume only with the same input and options
+    Args string
+    // Sentences written and input offset after the last of them
+    Seq int
+    Offset int64
+    // Flushed output sizes
+    OutSize int64
+    AlignSize int64
+    RejectSize int64
+    // Dedupe set and rejections by rule
+    Keys map[string]bool
+    Rejects map[string]int
+}
+
+const g_checkpoint_fn = "sentences.checkpoint"
+
+func CheckpointLoad(fn string, args string) *Checkpoint {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) { return nil }
+    defer fs.Close()
+    
+    // Line reader
+    this := &Checkpoint{ Keys: map[string]bool{}, Rejects: map[string]int{} }
+    reader := bufio.NewReader(fs)
+    for err == nil {
+        // Read line
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil) { break }
+        
+        // Split
+        record := strings.SplitN(strings.TrimRight(line, "\n"), "\t", 2)
+        if (len(record) < 2) {
+            fmt.Printf("Error: Checkpoint line does not have enough columns!\n")
+            return nil
+        }
+        switch record[0] {
+            case "args": this.Args = record[1]
+            case "seq": this.Seq, _ = strconv.Atoi(record[1])
+            case "offset": this.Offset, _ = strconv.ParseInt(record[1], 10, 64)
+            case "out": this.OutSize, _ = strconv.ParseInt(record[1], 10, 64)
+            case "align": this.AlignSize, _ = strconv.ParseInt(record[1], 10, 64)
+            case "reject": this.RejectSize, _ = strconv.ParseInt(record[1], 10, 64)
+            case "key": this.Keys[record[1]] = true
+            case "rule":
+                split := strings.SplitN(record[1], "\t", 2)
+                if len(split) == 2 { this.Rejects[split[0]], _ = strconv.Atoi(split[1]) }
+        }
+    }
+    
+    // Check
+    if (this.Args != args) {
+        fmt.Printf("Warning: Checkpoint was made with different arguments, starting over\n")
+        return nil
+    }
+    return this
+}
+
+func (this *Checkpoint) Save(fn string) bool {
+    // Flush output
+    out_wr.Flush()
+    align_wr.Flush()
+    reject_wr.Flush()
+    var err error
+    this.OutSize, err = out_fs.Seek(0, io.SeekCurrent)
+    if (err == nil) { this.AlignSize, err = align_fs.Seek(0, io.SeekCurrent) }
+    if (err == nil) { this.RejectSize, err = reject_fs.Seek(0, io.SeekCurrent) }
+    if (err == nil) { err = out_fs.Sync() }
+    if (err == nil) { err = align_fs.Sync() }
+    if (err == nil) { err = reject_fs.Sync() }
+    if (err != nil) {
+        fmt.Printf("Error: Failed to flush output: %s\n", err.Error())
+        return false
+    }
+    
+    // Write to a temporary file and replace the old checkpoint
+    fs, err := os.OpenFile(fn + ".tmp", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open checkpoint file: %s\n", err.Error())
+        return false
+    }
+    wr := bufio.NewWriter(fs)
+    wr.WriteString(fmt.Sprintf("args\t%s\n", this.Args))
+    wr.WriteString(fmt.Sprintf("seq\t%d\n", this.Seq))
+    wr.WriteString(fmt.Sprintf("offset\t%d\n", this.Offset))
+    wr.WriteString(fmt.Sprintf("out\t%d\n", this.OutSize))
+    wr.WriteString(fmt.Sprintf("align\t%d\n", this.AlignSize))
+    wr.WriteString(fmt.Sprintf("reject\t%d\n", this.RejectSize))
+    for rule, count := range this.Rejects {
+        wr.WriteString(fmt.Sprintf("rule\t%s\t%d\n", rule, count))
+    }
+    for key, _ := range SentenceDb {
+        wr.WriteString(fmt.Sprintf("key\t%s\n", key))
+    }
+    err = wr.Flush()
+    if (err == nil) { err = fs.Sync() }
+    fs.Close()
+    if (err == nil) { err = os.Rename(fn + ".tmp", fn) }
+    if (err != nil) {
+        fmt.Printf("Error: Failed to write checkpoint: %s\n", err.Error())
+        return false
+    }
+    return true
+}
+
+// Output file for appending after the checkpointed size
+func checkpoint_open(fn string, size int64) (*os.File, error) {
+    fs, err := os.OpenFile(fn, os.O_WRONLY | os.O_CREATE, 0644)
+    if (err != nil) { return nil, err }
+    stat, err := fs.Stat()
+    if (err == nil && stat.Size() < size) { err = fmt.Errorf("file is shorter than checkpoint (%d < %d)", stat.Size(), size) }
+    if (err == nil) { err = fs.Truncate(size) }
+    if (err == nil) { _, err = fs.Seek(size, io.SeekStart) }
+    if (err != nil) {
+        fs.Close()
+        return nil, err
+    }
+    return fs, nil
+}
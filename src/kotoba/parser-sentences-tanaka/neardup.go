@@ -0,0 +1,260 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "bufio"
+    "strings"
+    "sort"
+    "strconv"
+    "math"
+    "unicode"
+    
+    // Kotoba
+    "kotoba/furigana"
+)
+
+// <===> Near duplicates <=====================================================>
+// Written sentence line with its normalized reading
+type NearItem struct {
+    Line string
+    Record []string
+    Tail []string
+    Grams []string
+    Score int
+    // Cluster
+    Parent int
+    Aliases []int
+    Sim map[int]float64
+}
+
+// Sentence final particles ignored at the end of the reading
+var g_near_final = []string{ "よ", "ね", "わ", "ぞ", "ぜ", "さ", "な", "か" }
+
+// Reading of the sentence without punctuation, width or final particle differences
+func near_normalize(spans furigana.SpanList) string {
+    // Reading of the spans
+    ret := []rune{}
+    for _, span := range spans {
+        str := span.Text
+        if len(span.Kana) > 0 { str = span.Kana }
+        for _, ch := range jcconv.ConvKataHira(str) {
+            // Full width ascii
+            if ch >= 0xff01 && ch <= 0xff5e { ch = ch - 0xff01 + 0x21 }
+            if unicode.IsPunct(ch) || unicode.IsSpace(ch) || unicode.IsSymbol(ch) { continue }
+            ret = append(ret, unicode.ToLower(ch))
+        }
+    }
+    
+    // Final particles, keeping at least a few characters
+    for i := 0; i < 2 && len(ret) > 4; i++ {
+        if !sfind(g_near_final, string(ret[len(ret) - 1])) { break }
+        ret = ret[:len(ret) - 1]
+    }
+    return string(ret)
+}
+
+// Character bigram set
+func near_grams(str string) []string {
+    arr := []rune(str)
+    if len(arr) < 2 { return []string{ str } }
+    seen := map[string]bool{}
+    ret := []string{}
+    for i := 0; i + 1 < len(arr); i++ {
+        gram := string(arr[i:i + 2])
+        if seen[gram] { continue }
+        seen[gram] = true
+        ret = append(ret, gram)
+    }
+    return ret
+}
+
+// Rarest grams first, shared prefix filter
+type NearGramFreq struct {
+    List []string
+    Freq map[string]int
+}
+func (this NearGramFreq) Len() int { return len(this.List) }
+func (this NearGramFreq) Swap(i, j int) { this.List[i], this.List[j] = this.List[j], this.List[i] }
+func (this NearGramFreq) Less(i, j int) bool {
+    fi := this.Freq[this.List[i]]
+    fj := this.Freq[this.List[j]]
+    if fi != fj { return fi < fj }
+    return this.List[i] < this.List[j]
+}
+
+func near_jaccard(a []string, b []string) float64 {
+    set := map[string]bool{}
+    for _, gram := range a { set[gram] = true }
+    n := 0
+    for _, gram := range b {
+        if set[gram] { n++ }
+    }
+    return float64(n) / float64(len(a) + len(b) - n)
+}
+
+// Example value of a sentence, curated links and readings without problems first
+func near_score(record []string) int {
+    score := 0
+    for _, item := range strings.Split(record[5], ";") {
+        dlist := strings.Split(item, "@")
+        if len(dlist) < 6 { continue }
+        score += 1
+        if dlist[5] == "1" { score += 2 }
+    }
+    for _, item := range strings.Split(record[6], ";") {
+        if strings.HasSuffix(item, "@" + ConfBad) { score -= 2 }
+    }
+    for _, span := range furigana.Parse(record[7]) {
+        if len(span.Kana) > 0 { score += 1 }
+    }
+    
+    // Quality filter score
+    if len(record) > 9 {
+        quality, _ := strconv.Atoi(record[9])
+        score += quality / 10
+    }
+    return score
+}
+
+func near_root(list []*NearItem, i int) int {
+    for list[i].Parent != i {
+        list[i].Parent = list[list[i].Parent].Parent
+        i = list[i].Parent
+    }
+    return i
+}
+
+// Cluster written sentences and keep the best of each cluster, the others become its aliases
+func near_merge(fn string, fn_alias string, threshold float64) bool {
+    // Read sentences
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return false
+    }
+    list := []*NearItem{}
+    freq := map[string]int{}
+    reader := bufio.NewReader(fs)
+    for err == nil {
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil) { break }
+        record := strings.Split(strings.TrimRight(line, "\n"), "\t")
+        if (len(record) < 8) {
+            fmt.Printf("Error: Line does not have enough columns! num=%d\n", len(record))
+            continue
+        }
+        item := &NearItem{
+            Line: line,
+            Record: record[0:8],
+            Tail: record[8:],
+            Grams: near_grams(near_normalize(furigana.Parse(record[7]))),
+            Score: near_score(record),
+            Parent: len(list),
+            Sim: map[int]float64{},
+        }
+        for _, gram := range item.Grams { freq[gram]++ }
+        list = append(list, item)
+    }
+    fs.Close()
+    
+    // Candidate pairs share a gram in the prefix of rarest grams
+    index := map[string][]int{}
+    for i, item := range list {
+        sort.Sort(NearGramFreq{ List: item.Grams, Freq: freq })
+        size := len(item.Grams)
+        prefix := size - int(math.Ceil(threshold * float64(size))) + 1
+        if prefix > size { prefix = size }
+        checked := map[int]bool{}
+        for _, gram := range item.Grams[0:prefix] {
+            for _, j := range index[gram] {
+                if checked[j] { continue }
+                checked[j] = true
+                other := list[j]
+                if float64(len(other.Grams)) < threshold * float64(size) || float64(size) < threshold * float64(len(other.Grams)) { continue }
+                sim := near_jaccard(item.Grams, other.Grams)
+                if sim < threshold { continue }
+                item.Sim[j] = sim
+                other.Sim[i] = sim
+                ri := near_root(list, i)
+                rj := near_root(list, j)
+                if ri != rj { list[ri].Parent = rj }
+            }
+            index[gram] = append(index[gram], i)
+        }
+    }
+    
+    // Best member of each cluster, earlier sentence on equal score
+    best := map[int]int{}
+    for i, item := range list {
+        root := near_root(list, i)
+        b, exists := best[root]
+        if !exists || item.Score > list[b].Score { best[root] = i }
+    }
+    for i, _ := range list {
+        b := best[near_root(list, i)]
+        if b != i { list[b].Aliases = append(list[b].Aliases, i) }
+    }
+    
+    // Output
+    out_fs, err := os.OpenFile(fn + ".tmp", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open output file: %s\n", err.Error())
+        return false
+    }
+    alias_fs, err := os.OpenFile(fn_alias, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open alias report file: %s\n", err.Error())
+        out_fs.Close()
+        return false
+    }
+    wr := bufio.NewWriter(out_fs)
+    alias_wr := bufio.NewWriter(alias_fs)
+    kept := 0
+    for i, item := range list {
+        if best[near_root(list, i)] != i { continue }
+        kept += 1
+        
+        // Alias idents, report with the similarity to the closest cluster member
+        aliases := ""
+        for _, j := range item.Aliases {
+            other := list[j]
+            if len(aliases) > 0 { aliases += ";" }
+            aliases += other.Record[0]
+            sim := other.Sim[i]
+            for _, value := range other.Sim {
+                if value > sim { sim = value }
+            }
+            alias_wr.WriteString(fmt.Sprintf("%s\t%s\t%.2f\t%s\n", item.Record[0], other.Record[0], sim, other.Record[1]))
+        }
+        // Aliases column followed by the rest
+        tail := ""
+        if len(item.Tail) > 1 { tail = "\t" + strings.Join(item.Tail[1:], "\t") }
+        wr.WriteString(strings.Join(item.Record, "\t") + "\t" + aliases + tail + "\n")
+    }
+    alias_wr.Flush()
+    alias_fs.Close()
+    err = wr.Flush()
+    out_fs.Close()
+    if (err != nil) {
+        fmt.Printf("Error: Failed to write output: %s\n", err.Error())
+        return false
+    }
+    fmt.Printf("Near duplicates: kept %d of %d sentences\n", kept, len(list))
+    
+    // Replace sentences, an interrupted run resumes from scratch instead of the changed file
+    os.Remove(g_checkpoint_fn)
+    err = os.Rename(fn + ".tmp", fn)
+    if (err != nil) {
+        fmt.Printf("Error: Failed to replace output: %s\n", err.Error())
+        return false
+    }
+    return true
+}
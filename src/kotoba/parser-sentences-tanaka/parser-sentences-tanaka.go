@@ -10,13 +10,20 @@ import (
     "flag"
     "fmt"
     "os"
-    "os/exec"
-    "bytes"
+    "os/signal"
+    "syscall"
+    "io"
     "bufio"
     "strings"
     "strconv"
     "unicode"
     "unicode/utf8"
+    "runtime"
+    "sync"
+    "regexp"
+    
+    // Kotoba
+    "kotoba/furigana"
 )
 
 type JCConv struct {
@@ -100,7 +107,7 @@ func (this *JCConv) IsHiragana(str_r []rune) bool {
     return true
 }
 
-func (this *JCConv) Inject(text string, furi string) string {
+func (this *JCConv) Inject(text string, furi string) (furigana.SpanList, error) {
     // Debug
     //fmt.Printf("* text='%s', furi='%s'\n", text, furi)
     
@@ -109,15 +116,15 @@ func (this *JCConv) Inject(text string, furi string) string {
     furi_r := jcconv.Rune(furi)
     
     // Loop until out of text
-    ret_s := ""
-    ret_e := ""
+    ret_s := furigana.SpanList{}
+    ret_e := furigana.SpanList{}
     for len(text_r) > 0 && len(furi_r) > 0 {
         // Hiragana and katakana (at start)
         tc := text_r[0]
         tc_kval, tc_kex := this.kata2hira[tc]
         if tc_kex { tc = tc_kval }
         if tc == furi_r[0] {
-            ret_s += this.Text(text_r[0:1])
+            ret_s = ret_s.Add(this.Text(text_r[0:1]), "")
             text_r = text_r[1:]
             furi_r = furi_r[1:]
             continue
@@ -128,7 +135,7 @@ func (this *JCConv) Inject(text string, furi string) string {
         tc_kval, tc_kex = this.kata2hira[tc]
         if tc_kex { tc = tc_kval }
         if tc == furi_r[len(furi_r) - 1] {
-            ret_e = this.Text(text_r[len(text_r) - 1:]) + ret_e
+            ret_e = furigana.SpanList{}.Add(this.Text(text_r[len(text_r) - 1:]), "").Join(ret_e)
             text_r = text_r[0:len(text_r) - 1]
             furi_r = furi_r[0:len(furi_r) - 1]
             continue
@@ -143,7 +150,9 @@ func (this *JCConv) Inject(text string, furi string) string {
             if exists { break }
             text_sz += 1
         }
-        if text_sz == 0 || len(furi_r) == 0 { panic(nil) }
+        if text_sz == 0 || len(furi_r) == 0 {
+            return furigana.SpanList{ furigana.Span{ Text: text, Kana: furi } }, fmt.Errorf("no kanji block at '%s' for '%s'", this.Text(text_r), this.Text(furi_r))
+        }
         
         // Furigana block
         furi_sz := len(furi_r)
@@ -156,16 +165,18 @@ func (this *JCConv) Inject(text string, furi string) string {
         }
         
         // Add annoted kanji
-        ret_s += "{" + this.Text(text_r[0:text_sz]) + ";" + this.Text(furi_r[0:furi_sz]) + "}"
+        ret_s = ret_s.Add(this.Text(text_r[0:text_sz]), this.Text(furi_r[0:furi_sz]))
         text_r = text_r[text_sz:]
         furi_r = furi_r[furi_sz:]
     }
     
-    // Leftovers
-    if len(text_r) > 0 { ret_s += this.Text(text_r) }
+    // Leftovers mean the reading does not cover the text
+    if len(text_r) > 0 || len(furi_r) > 0 {
+        return furigana.SpanList{ furigana.Span{ Text: text, Kana: furi } }, fmt.Errorf("leftover text '%s' and reading '%s'", this.Text(text_r), this.Text(furi_r))
+    }
     
     // Success
-    return ret_s + ret_e
+    return ret_s.Join(ret_e), nil
 }
 
 type Word struct {
@@ -174,110 +185,337 @@ type Word struct {
     Base string
 }
 
+// Base form readings shared by all workers
+var g_base_cache map[string]string
+var g_base_lock sync.Mutex
+
+// <===> Sentences <===========================================================>
+// Parsed sentence pair
+type Sentence struct {
+    Ident string
+    JpText string
+    JpParse string
+    En string
+    JpBase string
+    JpLink string
+    JpConf string
+    JpSpan string
+    // Duplicate check key
+    Key string
+    // Quality filter rule and details when rejected, score otherwise
+    Reject string
+    RejectInfo string
+    Quality int
+    // Furigana alignment failures
+    Align []string
+}
+
+type SentenceJob struct {
+    Seq int
+    Ident string
+    Jp string
+    En string
+    LineB string
+    // Input offset after this pair
+    Offset int64
+    Result *Sentence
+}
+
+// Sentence pair source, emits jobs in corpus order starting from an input offset
+type SentenceSource func(offset int64, emit func(job *SentenceJob)) error
+
 var SentenceDb map[string]bool
 
-func load(fn string) {
-    // File
-    fs, err := os.Open(fn)
-    if (err != nil) {
-        fmt.Printf("Failed to open file: " + err.Error() + "\n")
-        return
+func load(source SentenceSource, kind string, workers int, cp *Checkpoint) bool {
+    // Analysis workers, each with its own analyzer
+    jobs := make(chan *SentenceJob, workers * 16)
+    done := make(chan *SentenceJob, workers * 16)
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        an, err := AnalyzerOpen(kind)
+        if (err != nil) {
+            fmt.Printf("Error: Analyzer failure: %s\n", err.Error())
+            if i == 0 { return false }
+            break
+        }
+        wg.Add(1)
+        go func(an Analyzer) {
+            defer wg.Done()
+            defer an.Close()
+            for job := range jobs {
+                job.Result = parse(an, job)
+                done <- job
+            }
+        } (an)
     }
     
-    // Line reader
+    // Source reader
     fmt.Printf("Loading: ")
-    reader := bufio.NewReader(fs)
-    err = nil
-    for err == nil {
-        var line_a string
-        line_a, err = reader.ReadString('\n')
-        if (err != nil) { break }
-        _, err = reader.ReadString('\n')
-        if (err != nil) { break }
-        parse(line_a)
+    var source_err error
+    go func() {
+        seq := cp.Seq
+        source_err = source(cp.Offset, func(job *SentenceJob) {
+            job.Seq = seq
+            seq += 1
+            jobs <- job
+        })
+        close(jobs)
+        wg.Wait()
+        close(done)
+    } ()
+    
+    // Interrupt saves a checkpoint of what has been written so far
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(interrupt)
+    
+    // Output in input order
+    pending := map[int]*SentenceJob{}
+    next := cp.Seq
+    for {
+        var job *SentenceJob
+        select {
+            case job = <-done:
+            case <-interrupt:
+                fmt.Printf("\nInterrupted, saving checkpoint at %d\n", cp.Seq)
+                cp.Save(g_checkpoint_fn)
+                return false
+        }
+        if job == nil { break }
+        pending[job.Seq] = job
+        for {
+            item, exists := pending[next]
+            if !exists { break }
+            delete(pending, next)
+            next += 1
+            write(item.Result, cp)
+            cp.Seq = next
+            cp.Offset = item.Offset
+            
+            out_id += 1
+            if out_id % 1000 == 0 { fmt.Printf("%dk ", out_id / 1000) }
+            if g_checkpoint > 0 && out_id % g_checkpoint == 0 {
+                if !cp.Save(g_checkpoint_fn) { return false }
+            }
+        }
+    }
+    fmt.Printf("\n")
+    
+    // Unreadable source, keep a checkpoint of what was written
+    if (source_err != nil) {
+        fmt.Printf("Error: Sentence source failure: %s\n", source_err.Error())
+        cp.Save(g_checkpoint_fn)
+        return false
+    }
+    return true
+}
+
+func source_tanaka(fn string) SentenceSource {
+    return func(offset int64, emit func(job *SentenceJob)) error {
+        // File
+        fs, err := os.Open(fn)
+        if (err != nil) { return err }
+        defer fs.Close()
+        _, err = fs.Seek(offset, io.SeekStart)
+        if (err != nil) { return err }
         
-        out_id += 1
-        if out_id % 1000 == 0 { fmt.Printf("%dk ", out_id / 1000) }
+        // Line reader, A-line with the sentence pair and B-line with its words
+        reader := bufio.NewReader(fs)
+        for err == nil {
+            var line_a, line_b string
+            line_a, err = reader.ReadString('\n')
+            if (err != nil) { break }
+            line_b, err = reader.ReadString('\n')
+            if (err != nil) { break }
+            offset += int64(len(line_a) + len(line_b))
+            
+            // Trim usless parts and split
+            str := strings.TrimPrefix(line_a, "A: ")
+            
+            jp_ident := ""
+            idx := strings.Index(str, "#ID")
+            if (idx > 0) {
+                jp_ident = str[idx + 3:]
+                str = str[0:idx]
+                jp_ident = strings.TrimSpace(jp_ident)
+                jp_ident = strings.Trim(jp_ident, "=")
+                idx = strings.Index(jp_ident, "_")
+                if idx > 0 { jp_ident = jp_ident[idx + 1:] }
+            }
+            
+            split := strings.SplitN(str, "\t", 2)
+            if (len(split) != 2) {
+                fmt.Printf("Error: Could not split sentence to jp and en parts!\n")
+                continue
+            }
+            
+            emit(&SentenceJob{
+                Ident: jp_ident,
+                Jp: split[0],
+                En: split[1],
+                LineB: line_b,
+                Offset: offset,
+            })
+        }
+        if (err != io.EOF) { return err }
+        return nil
     }
 }
 
-func parse(str string) {
-    // Trim usless parts and split
-    str = strings.TrimPrefix(str, "A: ")
-    
-    jp_ident := ""
-    idx := strings.Index(str, "#ID")
-    if (idx > 0) {
-        jp_ident = str[idx + 3:]
-        str = str[0:idx]
-        jp_ident = strings.TrimSpace(jp_ident)
-        jp_ident = strings.Trim(jp_ident, "=")
-        idx = strings.Index(jp_ident, "_")
-        if idx > 0 { jp_ident = jp_ident[idx + 1:] }
-    }
-    
-    split := strings.SplitN(str, "\t", 2)
-    if (len(split) != 2) {
-        fmt.Printf("Error: Could not split sentence to jp and en parts!\n")
+func write(sentence *Sentence, cp *Checkpoint) {
+    if sentence == nil { return }
+    
+    // Alignment report
+    for _, item := range sentence.Align {
+        align_wr.WriteString(fmt.Sprintf("%s\t%s\t%s\n", sentence.Ident, item, sentence.JpText))
+    }
+    
+    // Quality filter report
+    if (sentence.Reject != "") {
+        reject_wr.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n", sentence.Reject, sentence.Ident, sentence.RejectInfo, sentence.JpText, sentence.En))
+        cp.Rejects[sentence.Reject] += 1
         return
     }
     
-    jp_text := strings.Replace(strings.TrimSpace(split[0]), "\t", " ", -1)
+    // Check dupes
+    _, dup_found := SentenceDb[sentence.Key]
+    if (dup_found) { return }
+    SentenceDb[sentence.Key] = true
+    
+    // Output
+    out_wr.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\t%d\n", sentence.Ident, sentence.JpText, sentence.JpParse, sentence.En, sentence.JpBase, sentence.JpLink, sentence.JpConf, sentence.JpSpan, sentence.Quality))
+}
+
+func parse(an Analyzer, job *SentenceJob) *Sentence {
+    // Sanitize
+    jp_text := strings.Replace(strings.TrimSpace(job.Jp), "\t", " ", -1)
     jp_text = strings.Replace(jp_text, ";", " ", -1)
     jp_text = strings.Replace(jp_text, "{", "[", -1)
     jp_text = strings.Replace(jp_text, "}", "]", -1)
     jp_text = strings.Replace(jp_text, "@", "(at)", -1)
-    en_text := strings.Replace(strings.TrimSpace(split[1]), "\t", " ", -1)
+    en_text := strings.Replace(strings.TrimSpace(job.En), "\t", " ", -1)
     
     // Analysis
-    jp_parse, jp_base := mecab(jp_text)
-    if (jp_parse == "") { return }
+    jp_spans, jp_base, jp_conf, jp_align, jp_unknown := analyze(an, jp_text, link_hints(job.LineB))
+    if (len(jp_spans) == 0) { return nil }
+    jp_parse := jp_spans.Format(g_furigana)
     
-    // Check dupes
-    _, dup_found := SentenceDb[jp_parse]
-    if (dup_found) { return }
-    SentenceDb[jp_parse] = true
+    // Curated word links
+    jp_link := parse_links(job.LineB, jp_spans.Plain())
     
-    // Output
-    out_wr.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n", jp_ident, jp_text, jp_parse, en_text, jp_base))
+    // Result
+    sentence := &Sentence{
+        Ident: job.Ident,
+        JpText: jp_text,
+        JpParse: jp_parse,
+        En: en_text,
+        JpBase: jp_base,
+        JpLink: jp_link,
+        JpConf: jp_conf,
+        JpSpan: jp_spans.Format("spans"),
+        Key: jp_spans.Format("kotoba"),
+        Align: jp_align,
+    }
+    
+    // Quality
+    sentence.Reject, sentence.RejectInfo = g_quality.Check(sentence, jp_unknown)
+    if (sentence.Reject == "") { sentence.Quality = g_quality.Score(sentence, jp_unknown) }
+    return sentence
 }
 
-func mecab(str string) (string, string) {
-    // Input sanitization
-    str = strings.Replace(str, "|", "", -1)
-    str = strings.Replace(str, "~", "", -1)
-    
+// B-line item: headword(reading)[sense]{form}~, optionally with a |N instance suffix
+var g_link_re = regexp.MustCompile(`^([^(\[{~|]+)(?:\(([^)]*)\))?(?:\[([0-9]+)\])?(?:\{([^}]*)\})?(~)?$`)
+var g_link_inst = regexp.MustCompile(`\|[0-9]+`)
+
+func link_items(str string) [][]string {
+    ret := [][]string{}
+    for _, item := range strings.Fields(strings.TrimSpace(strings.TrimPrefix(str, "B:"))) {
+        match := g_link_re.FindStringSubmatch(g_link_inst.ReplaceAllString(item, ""))
+        if match == nil {
+            fmt.Printf("Error: Could not parse B-line word! word='%s'\n", item)
+            continue
+        }
+        ret = append(ret, match)
+    }
+    return ret
+}
+
+// Curated headword readings of a B-line
+func link_hints(str string) map[string]string {
+    ret := map[string]string{}
+    for _, match := range link_items(str) {
+        if len(match[2]) > 0 { ret[match[1]] = match[2] }
+    }
+    return ret
+}
+
+func parse_links(str string, plain string) string {
+    // Each word in sentence order
+    linklist := ""
+    mark_pos := 0
+    for _, match := range link_items(str) {
+        head := match[1]
+        kana := match[2]
+        sense := match[3]
+        form := match[4]
+        good := "0"
+        if match[5] == "~" { good = "1" }
+        if form == "" { form = head }
+        if sense == "" { sense = "0" }
+        sense = strings.TrimLeft(sense, "0")
+        if sense == "" { sense = "0" }
+        
+        // Position of the form in sentence, searching onwards from the previous word
+        idx := strings.Index(plain[mark_pos:], form)
+        if idx >= 0 {
+            idx += mark_pos
+        } else {
+            idx = strings.Index(plain, form)
+            if idx < 0 { continue }
+        }
+        mark_pos = idx + len(form)
+        mark_s := utf8.RuneCountInString(plain[0:idx])
+        mark_e := mark_s + utf8.RuneCountInString(form)
+        
+        // Link
+        if (len(linklist) > 0) { linklist += ";" }
+        linklist += head + "@" + kana + "@" + sense + "@" + strconv.Itoa(mark_s) + "@" + strconv.Itoa(mark_e) + "@" + good
+    }
+    return linklist
+}
+
+func analyze(an Analyzer, str string, hints map[string]string) (furigana.SpanList, string, string, []string, float64) {
     // Execute
-    cmd := exec.Command("mecab", "--node-format=\"%ps|%pe|%m|%f[7]|%f[6]~\"", "--eos-format=\"\n\"", "--unk-format=\"%ps|%pe|%m|%m|%m~\"")
-    cmd.Stdin = strings.NewReader(str + "\n")
-    var out bytes.Buffer
-    cmd.Stdout = &out
-    err := cmd.Run()
+    tokens, err := an.Analyze(str)
     if (err != nil) {
-        fmt.Printf("Error: Mecab failure: %s\n", err.Error())
-        return "", ""
+        fmt.Printf("Error: Analyzer failure: %s\n", err.Error())
+        return nil, "", "", nil, 0
     }
-    txt_full := out.String()
     
-    // Result parsing
-    txt_full = strings.TrimSpace(txt_full)
-    txt_full = strings.Trim(txt_full, "~")
-    txt_split := strings.Split(txt_full, "~")
+    // Share of unknown words, punctuation excluded
+    words := 0
+    unknown := 0
+    for _, token := range tokens {
+        arr := jcconv.Rune(token.Surface)
+        if (len(arr) == 0 || unicode.IsPunct(arr[0]) || unicode.IsSymbol(arr[0]) || unicode.IsSpace(arr[0])) { continue }
+        words += 1
+        if (token.Unknown) { unknown += 1 }
+    }
+    unknown_share := 0.0
+    if (words > 0) { unknown_share = float64(unknown) / float64(words) }
     
     // Parse each word
-    sentence := ""
+    sentence := furigana.SpanList{}
     baselist := ""
+    conflist := ""
+    align := []string{}
     mark_off := 0
-    for _, item := range txt_split {
-        // Split
-        split := strings.Split(item, "|")
-        if (len(split) < 5) { continue }
-        
+    for _, token := range tokens {
         // Item
         word := Word{
-            Text: split[2],
-            Kana: split[3],
-            Base: split[4],
+            Text: token.Surface,
+            Kana: token.Reading,
+            Base: token.Base,
         }
         
         // Check
@@ -298,20 +536,34 @@ func mecab(str string) (string, string) {
         if (len(word.Kana) > 0) { word.Kana = jcconv.ConvKataHira(word.Kana) }
         if (word.Text == word.Kana) { word.Kana = "" }
         
-        // Furigana insertion
+        // Reading cross-check against the dictionary
+        if (len(word.Kana) > 0 && g_readings != nil) {
+            conf := ""
+            word.Kana, conf = g_readings.Check(word.Text, word.Kana, word.Base, hints[word.Base])
+            if (len(conflist) > 0) { conflist += ";" }
+            conflist += strconv.Itoa(mark_off) + "@" + strconv.Itoa(mark_off + mark_len) + "@" + conf
+        }
+        
+        // Furigana insertion, whole word ruby when alignment fails
         if (len(word.Kana) > 0) {
-            word.Text = jcconv.Inject(word.Text, word.Kana)
+            spans, err := jcconv.Inject(word.Text, word.Kana)
+            if (err != nil) { align = append(align, word.Text + "\t" + word.Kana + "\t" + err.Error()) }
+            sentence = sentence.Join(spans)
+        } else {
+            sentence = sentence.Add(word.Text, "")
         }
         
         // Sentence
-        sentence += word.Text
         if (len(word.Base) > 0) {
             base_r := jcconv.Rune(word.Base)
             is_hiragana := jcconv.IsHiragana(base_r)
             if (!is_hiragana || len(base_r) > 1) {
                 if (len(baselist) > 0) { baselist += ";" }
                 base_h := word.Base
-                if !is_hiragana { base_h = mecab_base(word.Base) }
+                if !is_hiragana { base_h = analyze_base(an, word.Base) }
+                if !is_hiragana && g_readings != nil {
+                    base_h, _ = g_readings.Check(word.Base, base_h, word.Base, hints[word.Base])
+                }
                 baselist += word.Base + "@" + base_h + "@" + strconv.Itoa(mark_off) + "@" + strconv.Itoa(mark_off + mark_len)
             }
         }
@@ -322,72 +574,175 @@ func mecab(str string) (string, string) {
     //fmt.Printf("* %s  (%v)\n", sentence, baselist)
     
     // Return
-    return sentence, baselist
+    return sentence, baselist, conflist, align, unknown_share
 }
 
-func mecab_base(str string) string {
-    // Input sanitization
-    str = strings.Replace(str, "|", "", -1)
-    str = strings.Replace(str, "~", "", -1)
-
+func analyze_base(an Analyzer, str string) string {
+    // Cached reading
+    g_base_lock.Lock()
+    ret, exists := g_base_cache[str]
+    g_base_lock.Unlock()
+    if exists { return ret }
+    
     // Execute
-    cmd := exec.Command("mecab", "--node-format=\"%ps|%pe|%m|%f[7]|%f[6]~\"", "--eos-format=\"\n\"", "--unk-format=\"%ps|%pe|%m|%m|%m~\"")
-    cmd.Stdin = strings.NewReader(str + "\n")
-    var out bytes.Buffer
-    cmd.Stdout = &out
-    err := cmd.Run()
+    tokens, err := an.Analyze(str)
     if (err != nil) {
-        fmt.Printf("Error: Mecab failure: %s\n", err.Error())
-        return ""
+        fmt.Printf("Error: Analyzer failure: %s\n", err.Error())
+    } else if (len(tokens) > 0) {
+        ret = jcconv.ConvKataHira(tokens[0].Reading)
     }
-    txt_full := out.String()
-    
-    // Result parsing
-    txt_full = strings.TrimSpace(txt_full)
-    txt_full = strings.Trim(txt_full, "~")
-    txt_split := strings.Split(txt_full, "~")
-    
-    // Split
-    split := strings.Split(txt_split[0], "|")
-    if (len(split) < 5) { return "" }
     
     // Return hiragana
-    return jcconv.ConvKataHira(split[3]);
+    g_base_lock.Lock()
+    g_base_cache[str] = ret
+    g_base_lock.Unlock()
+    return ret
 }
 
 // File stream
 var out_fs *os.File
 var out_wr *bufio.Writer
 var out_id int
+var g_checkpoint int
+var g_furigana string
+var align_fs *os.File
+var align_wr *bufio.Writer
+var reject_fs *os.File
+var reject_wr *bufio.Writer
 
 // Main
 func main() {
     // Flags
     fn_t := flag.String("tanaka", "", "Tanaka corpus file")
+    dir_t := flag.String("tatoeba", "", "Directory with Tatoeba sentences.csv, links.csv and jpn_indices.csv exports")
+    workers := flag.Int("workers", runtime.NumCPU(), "Number of analyzer workers")
+    kind := flag.String("analyzer", "mecab", "Morphological analyzer (mecab or table)")
+    fields := flag.String("mecab-fields", "7,6,0", "MeCab feature positions of reading, base form and part of speech")
+    table := flag.String("table", "", "Canned analyses for the table analyzer")
+    fn_w := flag.String("words", "", "Optional JMdict words file for reading checks")
+    min_length := flag.Int("min-length", 4, "Minimum sentence length in characters")
+    max_length := flag.Int("max-length", 60, "Maximum sentence length in characters, 0 for no limit")
+    max_unknown := flag.Float64("max-unknown", 0.2, "Maximum share of words unknown to the analyzer")
+    min_ratio := flag.Float64("min-ratio", 0.5, "Minimum English to Japanese length ratio")
+    max_ratio := flag.Float64("max-ratio", 8, "Maximum English to Japanese length ratio, 0 for no limit")
+    banned := flag.String("banned", "#＃<>＜＞|｜_\\\ufffd", "Characters not allowed in sentences")
+    near := flag.Float64("near", 0.8, "Bigram similarity of near duplicate sentences, 0 disables")
+    checkpoint := flag.Int("checkpoint", 5000, "Sentences between checkpoints, 0 disables")
+    fmt_furigana := flag.String("furigana", "kotoba", "Furigana format (" + strings.Join(furigana.Formats, ", ") + ")")
     flag.Parse()
     
     // Check
-    if (*fn_t == "") {
-        fmt.Printf("Please specify Tanaka corpus file!\n")
+    if (*fn_t == "" && *dir_t == "") {
+        fmt.Printf("Please specify Tanaka corpus file or Tatoeba export directory!\n")
+        return
+    }
+    if (*workers < 1) { *workers = 1 }
+    if (!sfind(furigana.Formats, *fmt_furigana)) {
+        fmt.Printf("Unknown furigana format '%s'!\n", *fmt_furigana)
+        return
+    }
+    g_furigana = *fmt_furigana
+    if (*kind == "table" && *table == "") {
+        fmt.Printf("Please specify table analyzer file!\n")
         return
     }
+    g_table = *table
+    for i, str := range strings.Split(*fields, ",") {
+        if i < len(g_mecab_fields) { g_mecab_fields[i], _ = strconv.Atoi(strings.TrimSpace(str)) }
+    }
+    
+    g_checkpoint = *checkpoint
+    g_quality = &QualityRules{
+        MinLength: *min_length,
+        MaxLength: *max_length,
+        MaxUnknown: *max_unknown,
+        MinRatio: *min_ratio,
+        MaxRatio: *max_ratio,
+        Banned: *banned,
+    }
+    
+    // Resume from checkpoint of an earlier run with the same arguments
+    args := fmt.Sprintf("tanaka=%s tatoeba=%s analyzer=%s mecab-fields=%s table=%s words=%s furigana=%s quality=%v",
+        *fn_t, *dir_t, *kind, *fields, *table, *fn_w, *fmt_furigana, *g_quality)
+    cp := CheckpointLoad(g_checkpoint_fn, args)
+    resume := cp != nil
     
     // Output file
-    out_id = 0
     var err error
-    out_fs, err = os.OpenFile("sentences.pipe", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (resume) {
+        fmt.Printf("Resuming from checkpoint at %d\n", cp.Seq)
+        out_fs, err = checkpoint_open("sentences.pipe", cp.OutSize)
+    } else {
+        cp = &Checkpoint{ Args: args, Keys: map[string]bool{}, Rejects: map[string]int{} }
+        out_fs, err = os.OpenFile("sentences.pipe", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    }
     if (err != nil) {
         fmt.Printf("Failed to open output file: %s\n", err.Error())
         return
     }
     out_wr = bufio.NewWriter(out_fs)
+    if (resume) {
+        align_fs, err = checkpoint_open("sentences-alignment.txt", cp.AlignSize)
+    } else {
+        align_fs, err = os.OpenFile("sentences-alignment.txt", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    }
+    if (err != nil) {
+        fmt.Printf("Failed to open alignment report file: %s\n", err.Error())
+        return
+    }
+    align_wr = bufio.NewWriter(align_fs)
+    if (resume) {
+        reject_fs, err = checkpoint_open("sentences-rejected.txt", cp.RejectSize)
+    } else {
+        reject_fs, err = os.OpenFile("sentences-rejected.txt", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    }
+    if (err != nil) {
+        fmt.Printf("Failed to open rejection report file: %s\n", err.Error())
+        return
+    }
+    reject_wr = bufio.NewWriter(reject_fs)
+    out_id = cp.Seq
     
     // Charconv
     jcconv.Init()
 
-    // Sentence db
-    SentenceDb = map[string]bool{}
+    // Dictionary readings
+    if (*fn_w != "") {
+        g_readings = ReadingLoad(*fn_w)
+        if (g_readings == nil) { return }
+    }
+
+    // Sentence db and base reading cache
+    SentenceDb = cp.Keys
+    g_base_cache = map[string]string{}
 
     // Load
-    load(*fn_t)
+    done := false
+    if (*fn_t != "") {
+        done = load(source_tanaka(*fn_t), *kind, *workers, cp)
+    } else {
+        done = load(source_tatoeba(*dir_t), *kind, *workers, cp)
+    }
+    
+    // Close
+    out_wr.Flush()
+    out_fs.Close()
+    align_wr.Flush()
+    align_fs.Close()
+    reject_wr.Flush()
+    reject_fs.Close()
+    
+    // Quality filter summary
+    for _, rule := range g_quality_rules {
+        if (cp.Rejects[rule] > 0) { fmt.Printf("Rejected by %s: %d\n", rule, cp.Rejects[rule]) }
+    }
+    
+    // Near duplicates
+    if (done && *near > 0) {
+        fmt.Printf("Merging near duplicates...\n")
+        done = near_merge("sentences.pipe", "sentences-aliases.txt", *near)
+    }
+    
+    // Finished run needs no checkpoint
+    if (done) { os.Remove(g_checkpoint_fn) }
 }
@@ -0,0 +1,64 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "testing"
+)
+
+// Sentence stage through the table analyzer
+func TestParseTable(t *testing.T) {
+    // Setup
+    jcconv.Init()
+    g_base_cache = map[string]string{}
+    g_readings = nil
+    g_furigana = "kotoba"
+    g_quality = &QualityRules{ MinLength: 4, MaxLength: 60, MaxUnknown: 0.2, MinRatio: 0.5, MaxRatio: 8 }
+    an, err := TableOpen("testdata/analysis.table")
+    if err != nil { t.Fatalf("table: %s", err.Error()) }
+    
+    // Jobs and expected columns
+    cases := []struct {
+        job SentenceJob
+        parse string
+        base string
+        link string
+        span string
+    }{
+        {
+            SentenceJob{ Ident: "1", Jp: "私は寿司を食べます。", En: "I eat sushi.", LineB: "私(わたし) は 寿司 を 食べる{食べ}~" },
+            "{私;わたし}は{寿司;すし}を{食;た}べます。",
+            "私@わたし@0@1;寿司@すし@2@4;食べる@たべる@5@7;ます@ます@7@9",
+            "私@わたし@0@0@1@0;は@@0@1@2@0;寿司@@0@2@4@0;を@@0@4@5@0;食べる@@0@5@7@1",
+            "私@わたし;は@;寿司@すし;を@;食@た;べます。@",
+        },
+        {
+            SentenceJob{ Ident: "2", Jp: "今日学校に行きます。", En: "I go to school today.", LineB: "今日(きょう) 学校 に 行く{行き}" },
+            "{今日;きょう}{学校;がっこう}に{行;い}きます。",
+            "今日@きょう@0@2;学校@がっこう@2@4;行く@いく@5@7;ます@ます@7@9",
+            "今日@きょう@0@0@2@0;学校@@0@2@4@0;に@@0@4@5@0;行く@@0@5@7@0",
+            "今日@きょう;学校@がっこう;に@;行@い;きます。@",
+        },
+        {
+            SentenceJob{ Ident: "3", Jp: "東京に住んでいる。", En: "I live in Tokyo.", LineB: "東京 に 住む{住んで} 居る(いる){いる}" },
+            "{東京;とうきょう}に{住;す}んでいる。",
+            "東京@とうきょう@0@2;住む@すむ@3@6;いる@いる@6@8",
+            "東京@@0@0@2@0;に@@0@2@3@0;住む@@0@3@6@0;居る@いる@0@6@8@0",
+            "東京@とうきょう;に@;住@す;んでいる。@",
+        },
+    }
+    for _, item := range cases {
+        job := item.job
+        sentence := parse(an, &job)
+        if sentence == nil { t.Fatalf("%s: no result", job.Ident) }
+        if sentence.Reject != "" { t.Errorf("%s: rejected by %s", job.Ident, sentence.Reject) }
+        if sentence.JpParse != item.parse { t.Errorf("%s: parse '%s', expected '%s'", job.Ident, sentence.JpParse, item.parse) }
+        if sentence.JpBase != item.base { t.Errorf("%s: base '%s', expected '%s'", job.Ident, sentence.JpBase, item.base) }
+        if sentence.JpLink != item.link { t.Errorf("%s: link '%s', expected '%s'", job.Ident, sentence.JpLink, item.link) }
+        if sentence.JpSpan != item.span { t.Errorf("%s: span '%s', expected '%s'", job.Ident, sentence.JpSpan, item.span) }
+    }
+}
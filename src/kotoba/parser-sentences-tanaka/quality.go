@@ -0,0 +1,96 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// <===> Quality <=============================================================>
+// Rule limits of the sentence quality filter
+type QualityRules struct {
+    MinLength int
+    MaxLength int
+    MaxUnknown float64
+    MinRatio float64
+    MaxRatio float64
+    Banned string
+}
+
+// Rule names in report order
+const (
+    RuleEnglish = "english"
+    RuleLength = "length"
+    RuleUnknown = "unknown"
+    RuleRatio = "ratio"
+    RuleBanned = "banned"
+)
+var g_quality_rules = []string{ RuleEnglish, RuleLength, RuleUnknown, RuleRatio, RuleBanned }
+
+// Sentence lengths without penalty
+const g_quality_short = 8
+const g_quality_long = 30
+
+var g_quality *QualityRules
+
+// Rule the sentence breaks with details, empty when accepted
+func (this *QualityRules) Check(sentence *Sentence, unknown float64) (string, string) {
+    jp_len := utf8.RuneCountInString(sentence.JpText)
+    en_len := utf8.RuneCountInString(sentence.En)
+    
+    // English with some words
+    letters := 0
+    for _, ch := range sentence.En {
+        if unicode.IsLetter(ch) { letters++ }
+    }
+    if letters == 0 { return RuleEnglish, fmt.Sprintf("letters=%d", letters) }
+    if strings.ContainsRune(sentence.En, utf8.RuneError) { return RuleEnglish, "invalid encoding" }
+    
+    // Limits
+    if jp_len < this.MinLength || (this.MaxLength > 0 && jp_len > this.MaxLength) {
+        return RuleLength, fmt.Sprintf("length=%d", jp_len)
+    }
+    if unknown > this.MaxUnknown {
+        return RuleUnknown, fmt.Sprintf("unknown=%.2f", unknown)
+    }
+    ratio := float64(en_len) / float64(jp_len)
+    if ratio < this.MinRatio || (this.MaxRatio > 0 && ratio > this.MaxRatio) {
+        return RuleRatio, fmt.Sprintf("ratio=%.2f", ratio)
+    }
+    if idx := strings.IndexAny(sentence.JpText + "\t" + sentence.En, this.Banned); idx >= 0 && len(this.Banned) > 0 {
+        ch, _ := utf8.DecodeRuneInString((sentence.JpText + "\t" + sentence.En)[idx:])
+        return RuleBanned, fmt.Sprintf("char='%c'", ch)
+    }
+    return "", ""
+}
+
+// Quality score from 0 to 100 of an accepted sentence
+func (this *QualityRules) Score(sentence *Sentence, unknown float64) int {
+    score := 100.0
+    
+    // Unknown words
+    score -= unknown * 100
+    
+    // Distance from comfortable length
+    jp_len := utf8.RuneCountInString(sentence.JpText)
+    if jp_len < g_quality_short { score -= float64(2 * (g_quality_short - jp_len)) }
+    if jp_len > g_quality_long { score -= float64(2 * (jp_len - g_quality_long)) }
+    
+    // Translation of unusual length
+    ratio := float64(utf8.RuneCountInString(sentence.En)) / float64(jp_len)
+    if ratio < 1 || ratio > 5 { score -= 20 }
+    
+    // Furigana problems
+    score -= float64(10 * len(sentence.Align))
+    score -= float64(5 * strings.Count(sentence.JpConf, "@" + ConfBad))
+    
+    if score < 0 { score = 0 }
+    return int(score)
+}
@@ -0,0 +1,150 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "bufio"
+    "encoding/xml"
+    "strings"
+)
+
+// <===> Readings <============================================================>
+// Words file structures from parser-words-jmdict
+type WordSaveRoot struct {
+    XMLName xml.Name `xml:"Words"`
+    Entry []WordSaveEntry
+}
+
+type WordSaveEntry struct {
+    Id string
+    Kele []WordSaveKele
+    Rele []WordSaveRele
+}
+
+type WordSaveKele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+}
+
+type WordSaveRele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+    Restr string `xml:"restr,attr,omitempty"`
+    Nokanji bool `xml:"nokanji,attr,omitempty"`
+}
+
+// Furigana confidence
+const (
+    ConfOk = "ok"
+    ConfFixed = "fixed"
+    ConfBad = "bad"
+    ConfUnknown = "unknown"
+)
+
+// Valid hiragana readings of each JMdict spelling
+type ReadingDb struct {
+    Readings map[string][]string
+}
+
+var g_readings *ReadingDb
+
+func ReadingLoad(fn string) *ReadingDb {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return nil
+    }
+    defer fs.Close()
+    
+    // XML reader
+    save := WordSaveRoot{}
+    decoder := xml.NewDecoder(bufio.NewReader(fs))
+    decoder.Strict = false
+    err = decoder.Decode(&save)
+    if err != nil {
+        fmt.Printf("XML error: %s\n", err.Error())
+        return nil
+    }
+    
+    // Spelling to reading map honouring reading restrictions
+    this := &ReadingDb{ Readings: map[string][]string{} }
+    for _, entry := range save.Entry {
+        for _, kele := range entry.Kele {
+            for _, rele := range entry.Rele {
+                if rele.Nokanji { continue }
+                if len(rele.Restr) > 0 && !sfind(strings.Split(rele.Restr, ";"), kele.Text) { continue }
+                kana := jcconv.ConvKataHira(rele.Text)
+                if !sfind(this.Readings[kele.Text], kana) {
+                    this.Readings[kele.Text] = append(this.Readings[kele.Text], kana)
+                }
+            }
+        }
+    }
+    return this
+}
+
+// Stem readings of irregular verbs, dictionary form reading first
+var g_reading_stem = map[rune][]string{
+    '来': []string{ "く", "き", "こ" },
+    '來': []string{ "く", "き", "こ" },
+    '為': []string{ "す", "し", "さ", "せ" },
+}
+
+func sfind(list []string, str string) bool {
+    for _, item := range list {
+        if item == str { return true }
+    }
+    return false
+}
+
+// Readings the surface can have as a form of the base headword
+func (this *ReadingDb) Expect(surface string, base string, hint string) []string {
+    // Valid readings of the headword, the curated one when known
+    valid := this.Readings[base]
+    if len(hint) > 0 { valid = []string{ jcconv.ConvKataHira(hint) } }
+    
+    // Shared stem of surface and base, the rest is inflection in kana
+    surface_r := jcconv.Rune(surface)
+    base_r := jcconv.Rune(base)
+    n := 0
+    for n < len(surface_r) && n < len(base_r) && surface_r[n] == base_r[n] { n++ }
+    if n == 0 { return []string{} }
+    suffix_s := jcconv.ConvKataHira(jcconv.Text(surface_r[n:]))
+    suffix_b := jcconv.ConvKataHira(jcconv.Text(base_r[n:]))
+    
+    // Irregular verb stems change their reading when inflected
+    stems := []string{}
+    if surface != base { stems = g_reading_stem[base_r[n - 1]] }
+    
+    // Stem readings with the surface inflection
+    ret := []string{}
+    for _, kana := range valid {
+        if !strings.HasSuffix(kana, suffix_b) { continue }
+        stem := strings.TrimSuffix(kana, suffix_b)
+        forms := []string{ stem }
+        if len(stems) > 0 && strings.HasSuffix(stem, stems[0]) {
+            for _, item := range stems[1:] { forms = append(forms, strings.TrimSuffix(stem, stems[0]) + item) }
+        }
+        for _, item := range forms {
+            item += suffix_s
+            if !sfind(ret, item) { ret = append(ret, item) }
+        }
+    }
+    return ret
+}
+
+// Verify a token reading, correcting it when the dictionary allows only one
+func (this *ReadingDb) Check(surface string, kana string, base string, hint string) (string, string) {
+    expect := this.Expect(surface, base, hint)
+    if len(expect) == 0 { return kana, ConfUnknown }
+    if sfind(expect, kana) { return kana, ConfOk }
+    if len(expect) == 1 { return expect[0], ConfFixed }
+    return kana, ConfBad
+}
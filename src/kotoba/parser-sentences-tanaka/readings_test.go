@@ -0,0 +1,40 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "testing"
+)
+
+// Reading checks of regular and irregular inflections
+func TestReadingCheck(t *testing.T) {
+    // Setup
+    jcconv.Init()
+    db := &ReadingDb{ Readings: map[string][]string{
+        "来る": []string{ "くる" },
+        "食べる": []string{ "たべる" },
+        "持って来る": []string{ "もってくる" },
+    } }
+    
+    // Surface, analyzer reading, base and expected reading and confidence
+    cases := [][]string{
+        { "食べた", "たべた", "食べる", "たべた", ConfOk },
+        { "食べた", "しょくべた", "食べる", "たべた", ConfFixed },
+        { "来る", "くる", "来る", "くる", ConfOk },
+        { "来た", "きた", "来る", "きた", ConfOk },
+        { "来ない", "こない", "来る", "こない", ConfOk },
+        { "来ます", "きます", "来る", "きます", ConfOk },
+        { "来た", "らいた", "来る", "らいた", ConfBad },
+        { "持って来た", "もってきた", "持って来る", "もってきた", ConfOk },
+    }
+    for _, item := range cases {
+        kana, conf := db.Check(item[0], item[1], item[2], "")
+        if kana != item[3] || conf != item[4] {
+            t.Errorf("%s (%s): got %s %s, expected %s %s", item[0], item[1], kana, conf, item[3], item[4])
+        }
+    }
+}
@@ -0,0 +1,124 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "fmt"
+    "os"
+    "io"
+    "bufio"
+    "path/filepath"
+    "strings"
+    "strconv"
+    "sort"
+)
+
+// <===> Tatoeba <=============================================================>
+// Japanese sentence with its English translation and word index
+type TatoebaPair struct {
+    Id int
+    Jp string
+    En int
+    Meaning int
+    Index string
+}
+
+type TatoebaPairId []*TatoebaPair
+func (list TatoebaPairId) Len() int { return len(list) }
+func (list TatoebaPairId) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list TatoebaPairId) Less(i, j int) bool { return list[i].Id < list[j].Id }
+
+// Read tab separated Tatoeba export, calling fn for every record
+func tatoeba_read(fn string, cols int, fn_rec func(record []string)) bool {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return false
+    }
+    defer fs.Close()
+    
+    // Line reader
+    reader := bufio.NewReader(fs)
+    for err == nil {
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil && len(line) == 0) { break }
+        
+        // Split
+        record := strings.SplitN(strings.TrimRight(line, "\r\n"), "\t", cols)
+        if (len(record) < cols) {
+            fmt.Printf("Error: Line does not have enough columns! num=%d\n", len(record))
+            continue
+        }
+        fn_rec(record)
+    }
+    if (err != io.EOF) {
+        fmt.Printf("Failed to read file: %s\n", err.Error())
+        return false
+    }
+    return true
+}
+
+func source_tatoeba(dir string) SentenceSource {
+    return func(offset int64, emit func(job *SentenceJob)) error {
+        // Japanese and English sentences
+        jpn := map[int]*TatoebaPair{}
+        eng := map[int]string{}
+        fn := filepath.Join(dir, "sentences.csv")
+        if !tatoeba_read(fn, 3, func(record []string) {
+            id, err := strconv.Atoi(record[0])
+            if err != nil { return }
+            switch record[1] {
+                case "jpn": jpn[id] = &TatoebaPair{ Id: id, Jp: record[2], En: -1, Meaning: -1 }
+                case "eng": eng[id] = record[2]
+            }
+        }) { return fmt.Errorf("could not read %s", fn) }
+        
+        // Translations, lowest English sentence id first
+        fn = filepath.Join(dir, "links.csv")
+        if !tatoeba_read(fn, 2, func(record []string) {
+            a, _ := strconv.Atoi(record[0])
+            b, _ := strconv.Atoi(record[1])
+            pair, exists := jpn[a]
+            if !exists { return }
+            if _, exists = eng[b]; !exists { return }
+            if pair.En < 0 || b < pair.En { pair.En = b }
+        }) { return fmt.Errorf("could not read %s", fn) }
+        
+        // Word indices, their meaning id picks the translation when present
+        tatoeba_read(filepath.Join(dir, "jpn_indices.csv"), 3, func(record []string) {
+            id, _ := strconv.Atoi(record[0])
+            pair, exists := jpn[id]
+            if !exists { return }
+            pair.Meaning, _ = strconv.Atoi(record[1])
+            pair.Index = record[2]
+        })
+        
+        // Pairs in sentence id order
+        list := TatoebaPairId{}
+        for _, pair := range jpn {
+            if _, exists := eng[pair.Meaning]; exists { pair.En = pair.Meaning }
+            if pair.En >= 0 { list = append(list, pair) }
+        }
+        sort.Sort(list)
+        fmt.Printf("(%d of %d Japanese sentences translated) ", len(list), len(jpn))
+        
+        // Emit, offset counts pairs
+        for i := int(offset); i < len(list); i++ {
+            pair := list[i]
+            emit(&SentenceJob{
+                Ident: strconv.Itoa(pair.Id),
+                Jp: pair.Jp,
+                En: eng[pair.En],
+                LineB: pair.Index,
+                Offset: int64(i + 1),
+            })
+        }
+        return nil
+    }
+}
@@ -7,6 +7,7 @@
 package main
 import (
     // System
+    "flag"
     "fmt"
     "os"
     "bufio"
@@ -16,6 +17,8 @@ import (
     "encoding/binary"
     "strings"
     "strconv"
+    "sort"
+    "unicode"
 )
 
 var g_bo binary.ByteOrder
@@ -36,19 +39,69 @@ type DictEntry struct {
 
 type DictKele struct {
     Keb string `xml:"keb"`
+    KeInf []string `xml:"ke_inf"`
     KePri []string `xml:"ke_pri"`
 }
 
 type DictRele struct {
     Reb string `xml:"reb"`
+    ReNokanji *struct{} `xml:"re_nokanji"`
+    ReRestr []string `xml:"re_restr"`
+    ReInf []string `xml:"re_inf"`
     RePri []string `xml:"re_pri"`
 }
 
 type DictSense struct {
-    Pos string `xml:"pos"`
+    Stagk []string `xml:"stagk"`
+    Stagr []string `xml:"stagr"`
+    Pos []string `xml:"pos"`
+    Misc []string `xml:"misc"`
     Gloss []string `xml:"gloss"`
 }
 
+// Spelling status ranks, normal forms first and search-only forms last
+// (ateji and gikun are often the usual spelling so they keep their place)
+var g_inf_rank = map[string]int{
+    "iK": 1,
+    "ik": 1,
+    "io": 1,
+    "rK": 2,
+    "rk": 2,
+    "oK": 3,
+    "ok": 3,
+    "sK": 4,
+    "sk": 4,
+}
+
+func dict_entity(str string) string {
+    str = strings.Replace(str, "&", "", -1)
+    return strings.Trim(str, ";")
+}
+
+func dict_inf_rank(inf []string) int {
+    rank := 0
+    for _, str := range inf {
+        r, exists := g_inf_rank[dict_entity(str)]
+        if exists && r > rank { rank = r }
+    }
+    return rank
+}
+
+type DictKeleRank []DictKele
+func (list DictKeleRank) Len() int { return len(list) }
+func (list DictKeleRank) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list DictKeleRank) Less(i, j int) bool { return dict_inf_rank(list[i].KeInf) < dict_inf_rank(list[j].KeInf) }
+
+type DictReleRank []DictRele
+func (list DictReleRank) Len() int { return len(list) }
+func (list DictReleRank) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list DictReleRank) Less(i, j int) bool {
+    ra := dict_inf_rank(list[i].ReInf)
+    rb := dict_inf_rank(list[j].ReInf)
+    if ra != rb { return ra < rb }
+    return list[i].ReNokanji == nil && list[j].ReNokanji != nil
+}
+
 // Save structure
 type WordSaveRoot struct {
     XMLName xml.Name `xml:"Words"`
@@ -57,35 +110,55 @@ type WordSaveRoot struct {
 
 type WordSaveEntry struct {
     Id string
-    Kele []string
-    Rele []string
+    Kele []WordSaveKele
+    Rele []WordSaveRele
     Sense []WordSaveSense
     Cat []string
 }
 
+type WordSaveKele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+}
+
+type WordSaveRele struct {
+    Text string `xml:",chardata"`
+    Inf string `xml:"inf,attr,omitempty"`
+    Restr string `xml:"restr,attr,omitempty"`
+    Nokanji bool `xml:"nokanji,attr,omitempty"`
+}
+
 type WordSaveSense struct {
     Pos string
+    Misc string `xml:",omitempty"`
     Gloss []string
+    Stagk []string
+    Stagr []string
 }
 
 // Load tanos JLPT levels
 type WordTanos struct {
     Hash string
+    Kele []string
+    Rele []string
+    En string
     Jlpt int
-    Used bool
+    // Match
+    Cand []*MatchCand
+    Entry *DictEntry
+    Override bool
 }
 
-func LoadTanos() (map[string]*WordTanos, map[string]*WordTanos) {
+func LoadTanos() []*WordTanos {
     // File
     fs, err := os.Open("words-tanos.pipe")
     if (err != nil) {
-        fmt.Printf("Failed to open file: " + err.Error() + "\n")
-        return nil, nil
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return nil
     }
     
-    // Kanji and kana maps
-    mk := map[string]*WordTanos{}
-    mr := map[string]*WordTanos{}
+    // Word list
+    ret := []*WordTanos{}
     
     // Line reader
     reader := bufio.NewReader(fs)
@@ -98,7 +171,7 @@ func LoadTanos() (map[string]*WordTanos, map[string]*WordTanos) {
         
         // Split
         record := strings.Split(line, "\t")
-        if (len(record) < 5) {
+        if (len(record) < 6) {
             fmt.Printf("Error: Line does not have enough columns! num=%d\n", len(record))
             continue
         }
@@ -107,53 +180,257 @@ func LoadTanos() (map[string]*WordTanos, map[string]*WordTanos) {
         hash := strings.TrimSpace(record[0])
         
         // Get kanji and kana
-        has_real := false
-        jp_real := strings.Split(strings.TrimSpace(record[1]), ";")
-        for i := range jp_real {
-            jp_real[i] = strings.TrimSpace(jp_real[i])
-            if len(jp_real[i]) > 0 { has_real = true }
+        jp_real := []string{}
+        for _, str := range strings.Split(record[1], ";") {
+            str = strings.TrimSpace(str)
+            if len(str) > 0 { jp_real = append(jp_real, str) }
+        }
+        jp_kana := []string{}
+        for _, str := range strings.Split(record[2], ";") {
+            str = strings.TrimSpace(str)
+            if len(str) > 0 { jp_kana = append(jp_kana, str) }
         }
-        has_kana := false
-        jp_kana := strings.Split(strings.TrimSpace(record[2]), ";")
-        for i := range jp_kana {
-            jp_kana[i] = strings.TrimSpace(jp_kana[i])
-            if len(jp_kana[i]) > 0 { has_kana = true }
+        
+        // Kana only words have their text in the kanji column
+        if len(jp_kana) == 0 {
+            jp_kana = jp_real
+            jp_real = []string{}
         }
+        if len(jp_kana) == 0 { continue }
         
         // Level
         jlpt, _ := strconv.Atoi(strings.TrimSpace(record[5]))
         
-        // Add to maps
-        word := &WordTanos{
+        // Add to list
+        ret = append(ret, &WordTanos{
             Hash: hash,
+            Kele: jp_real,
+            Rele: jp_kana,
+            En: strings.TrimSpace(record[3]),
             Jlpt: jlpt,
-            Used: false,
+        })
+    }
+    
+    // Return
+    return ret
+}
+
+// Load manual JLPT matches, one "hash<tab>ent_seq" per line ("-" for no match)
+func LoadOverride(fn string) map[string]string {
+    // File
+    ret := map[string]string{}
+    fs, err := os.Open(fn)
+    if (err != nil) { return ret }
+    
+    // Line reader
+    reader := bufio.NewReader(fs)
+    err = nil
+    for err == nil {
+        // Read line
+        var line string
+        line, err = reader.ReadString('\n')
+        if (err != nil && len(line) == 0) { break }
+        
+        // Skip comments and empty lines
+        line = strings.TrimSpace(line)
+        if len(line) == 0 || strings.HasPrefix(line, "#") { continue }
+        
+        // Split
+        record := strings.Fields(line)
+        if (len(record) < 2) {
+            fmt.Printf("Error: Override line does not have enough columns! line='%s'\n", line)
+            continue
+        }
+        ret[record[0]] = record[1]
+    }
+    
+    // Return
+    return ret
+}
+
+// <===> JLPT matching <=======================================================>
+type MatchCand struct {
+    Entry *DictEntry
+    Score int
+}
+
+type MatchCandScore []*MatchCand
+func (list MatchCandScore) Len() int { return len(list) }
+func (list MatchCandScore) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list MatchCandScore) Less(i, j int) bool { return list[i].Score > list[j].Score }
+
+// Candidates closer than this to the best one make the match ambiguous
+const g_match_margin = 3
+
+var g_match_stop = map[string]bool{
+    "the": true, "and": true, "for": true, "one": true, "with": true,
+    "something": true, "someone": true, "etc": true, "from": true,
+}
+
+type Matcher struct {
+    Kanji map[string][]*DictEntry
+    Kana map[string][]*DictEntry
+    Ident map[string]*DictEntry
+    Gloss map[*DictEntry]map[string]bool
+}
+
+func MatcherNew(dict *DictRoot) *Matcher {
+    // Instance
+    this := &Matcher{
+        Kanji: map[string][]*DictEntry{},
+        Kana: map[string][]*DictEntry{},
+        Ident: map[string]*DictEntry{},
+        Gloss: map[*DictEntry]map[string]bool{},
+    }
+    
+    // Spelling and reading indices
+    for _, entry := range dict.Entry {
+        this.Ident[entry.Id] = entry
+        for _, kele := range entry.Kele {
+            this.Kanji[kele.Keb] = append(this.Kanji[kele.Keb], entry)
+        }
+        for _, rele := range entry.Rele {
+            this.Kana[rele.Reb] = append(this.Kana[rele.Reb], entry)
+        }
+    }
+    
+    // Success
+    return this
+}
+
+func match_words(str string) []string {
+    ret := []string{}
+    for _, item := range strings.FieldsFunc(strings.ToLower(str), func(r rune) bool {
+        return !unicode.IsLetter(r)
+    }) {
+        if len(item) <= 2 { continue }
+        _, stop := g_match_stop[item]
+        if !stop { ret = append(ret, item) }
+    }
+    return ret
+}
+
+func (this *Matcher) GlossWords(entry *DictEntry) map[string]bool {
+    words, exists := this.Gloss[entry]
+    if exists { return words }
+    words = map[string]bool{}
+    for _, sense := range entry.Sense {
+        for _, gloss := range sense.Gloss {
+            for _, item := range match_words(gloss) { words[item] = true }
         }
-        if has_kana && has_real {
-            for _, str := range jp_real {
-                mk[str] = word
+    }
+    this.Gloss[entry] = words
+    return words
+}
+
+func (this *Matcher) Candidates(word *WordTanos) []*DictEntry {
+    ret := []*DictEntry{}
+    seen := map[*DictEntry]bool{}
+    add := func(list []*DictEntry) {
+        for _, entry := range list {
+            if !seen[entry] {
+                seen[entry] = true
+                ret = append(ret, entry)
             }
-            for _, str := range jp_kana {
-                mr[str] = word
+        }
+    }
+    // Kanji spelling first, reading also catches variant spellings
+    for _, str := range word.Kele { add(this.Kanji[str]) }
+    for _, str := range word.Rele {
+        add(this.Kana[str])
+        if len(word.Kele) == 0 { add(this.Kanji[str]) }
+    }
+    return ret
+}
+
+func (this *Matcher) Score(word *WordTanos, entry *DictEntry) int {
+    score := 0
+    
+    // Spelling and reading agreement
+    kmatch := map[string]bool{}
+    for i, kele := range entry.Kele {
+        for _, str := range word.Kele {
+            if kele.Keb == str {
+                kmatch[str] = true
+                score += 4
+                if i == 0 { score += 1 }
             }
-        } else if has_real {
-            for _, str := range jp_real {
-                mr[str] = word
+        }
+    }
+    for _, rele := range entry.Rele {
+        for _, str := range word.Rele {
+            if rele.Reb != str { continue }
+            score += 2
+            if len(word.Kele) == 0 && len(entry.Kele) == 0 { score += 4 }
+            if rele.ReNokanji != nil { continue }
+            
+            // Kanji and kana pair
+            for kstr := range kmatch {
+                allowed := len(rele.ReRestr) == 0
+                for _, restr := range rele.ReRestr {
+                    if restr == kstr { allowed = true }
+                }
+                if allowed { score += 6 }
             }
         }
     }
     
-    // Return
-    return mk, mr
+    // Reading only match for a word with kanji spelling
+    if len(word.Kele) > 0 && len(kmatch) == 0 { score -= 4 }
+    
+    // Gloss overlap
+    gloss := this.GlossWords(entry)
+    overlap := 0
+    for _, item := range match_words(word.En) {
+        if gloss[item] { overlap += 1 }
+    }
+    if overlap > 4 { overlap = 4 }
+    score += overlap * 3
+    
+    // Common words
+    for _, kele := range entry.Kele {
+        if len(kele.KePri) > 0 { score += 2; break }
+    }
+    for _, rele := range entry.Rele {
+        if len(rele.RePri) > 0 { score += 1; break }
+    }
+    
+    // Result
+    return score
+}
+
+func (this *Matcher) Match(word *WordTanos) {
+    // Score all candidates
+    word.Cand = []*MatchCand{}
+    for _, entry := range this.Candidates(word) {
+        word.Cand = append(word.Cand, &MatchCand{
+            Entry: entry,
+            Score: this.Score(word, entry),
+        })
+    }
+    sort.Stable(MatchCandScore(word.Cand))
+    
+    // Best candidate
+    word.Entry = nil
+    if len(word.Cand) > 0 { word.Entry = word.Cand[0].Entry }
+}
+
+func (this *WordTanos) Ambiguous() bool {
+    return !this.Override && len(this.Cand) > 1 && this.Cand[0].Score - this.Cand[1].Score < g_match_margin
 }
 
 // Main
 func main() {
+    // Flags
+    fn_override := flag.String("override", "words-override.pipe", "Manual JLPT matches, hash and JMdict id or - per line")
+    flag.Parse()
+    
     // Dictionary
     dict := DictRoot{}
     
     // Tanos wordlist
-    jlpt_mk, jlpt_mr := LoadTanos()
+    jlpt_list := LoadTanos()
+    jlpt_override := LoadOverride(*fn_override)
     migmap := map[string]string{}
     
     // Save list
@@ -180,33 +457,33 @@ func main() {
     }
     for _, entry := range dict.Entry { entry.Jlpt = 0 }
     
-    // JLPT matching
-    fmt.Printf("JLPT matching...\n")
+    // Normal spellings and readings first
     for _, entry := range dict.Entry {
-        if entry.Jlpt == 0 {
-            for _, kele := range entry.Kele {
-                word, exists := jlpt_mk[kele.Keb]
-                if exists && !word.Used {
-                    word.Used = true
-                    entry.Jlpt = word.Jlpt
-                    migmap[word.Hash] = entry.Id
-                    break
-                }
-            }
-        }
+        sort.Stable(DictKeleRank(entry.Kele))
+        sort.Stable(DictReleRank(entry.Rele))
     }
-    for _, entry := range dict.Entry {
-        if entry.Jlpt == 0 {
-            for _, rele := range entry.Rele {
-                word, exists := jlpt_mr[rele.Reb]
-                if exists && !word.Used {
-                    word.Used = true
-                    entry.Jlpt = word.Jlpt
-                    migmap[word.Hash] = entry.Id
-                    break
-                }
+    
+    // JLPT matching
+    fmt.Printf("JLPT matching...\n")
+    matcher := MatcherNew(&dict)
+    for _, word := range jlpt_list {
+        // Manual override
+        id, exists := jlpt_override[word.Hash]
+        if exists {
+            word.Override = true
+            word.Cand = []*MatchCand{}
+            word.Entry = matcher.Ident[id]
+            if word.Entry == nil && id != "-" {
+                fmt.Printf("Error: Override entry not found! hash='%s', id='%s'\n", word.Hash, id)
             }
+        } else {
+            matcher.Match(word)
         }
+        
+        // Easier level wins when several words map to one entry
+        if word.Entry == nil { continue }
+        if word.Entry.Jlpt < word.Jlpt { word.Entry.Jlpt = word.Jlpt }
+        migmap[word.Hash] = word.Entry.Id
     }
     
     // Reformat
@@ -238,23 +515,44 @@ func main() {
         // Poulate save entry
         sentry := WordSaveEntry{
             Id: entry.Id,
-            Kele: []string{},
-            Rele: []string{},
+            Kele: []WordSaveKele{},
+            Rele: []WordSaveRele{},
             Sense: []WordSaveSense{},
             Cat: cat,
         }
         for _, kele := range entry.Kele {
-            sentry.Kele = append(sentry.Kele, kele.Keb)
+            inf := []string{}
+            for _, str := range kele.KeInf { inf = append(inf, dict_entity(str)) }
+            sentry.Kele = append(sentry.Kele, WordSaveKele{
+                Text: kele.Keb,
+                Inf: strings.Join(inf, ";"),
+            })
         }
         for _, rele := range entry.Rele {
-            sentry.Rele = append(sentry.Rele, rele.Reb)
+            inf := []string{}
+            for _, str := range rele.ReInf { inf = append(inf, dict_entity(str)) }
+            sentry.Rele = append(sentry.Rele, WordSaveRele{
+                Text: rele.Reb,
+                Inf: strings.Join(inf, ";"),
+                Restr: strings.Join(rele.ReRestr, ";"),
+                Nokanji: rele.ReNokanji != nil,
+            })
         }
+        pos := []string{}
         for _, sense := range entry.Sense {
-            pos := strings.Replace(sense.Pos, "&", "", -1)
-            pos = strings.Trim(pos, ";")
+            // Part of speech carries over to following senses until restated
+            if len(sense.Pos) > 0 {
+                pos = []string{}
+                for _, str := range sense.Pos { pos = append(pos, dict_entity(str)) }
+            }
+            misc := []string{}
+            for _, str := range sense.Misc { misc = append(misc, dict_entity(str)) }
             ssense := WordSaveSense{
-                Pos: pos,
+                Pos: strings.Join(pos, ";"),
+                Misc: strings.Join(misc, ";"),
                 Gloss: sense.Gloss,
+                Stagk: sense.Stagk,
+                Stagr: sense.Stagr,
             }
             sentry.Sense = append(sentry.Sense, ssense)
         }
@@ -278,6 +576,7 @@ func main() {
     // Migration map
     fmt.Printf("Writing migration map...\n")
     WriteMigmap(migmap)
+    WriteMigmapReport(jlpt_list)
 }
 
 func WriteWords(save *WordSaveRoot) {
@@ -338,3 +637,56 @@ func WriteMigmap(migmap map[string]string) {
     wr.Flush()
     fs.Close()
 }
+
+func WriteMigmapReport(list []*WordTanos) {
+    // File
+    fs, err := os.OpenFile("out-migmap-report.txt", os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open migration report output file: %s\n", err.Error())
+        return
+    }
+    wr := bufio.NewWriter(fs)
+    
+    // Word description
+    word_str := func(word *WordTanos) string {
+        return fmt.Sprintf("%s\t%s\t%s\t%s\tn%d", word.Hash,
+            strings.Join(word.Kele, ";"), strings.Join(word.Rele, ";"), word.En, word.Jlpt)
+    }
+    
+    // Sections
+    num_unmatched := 0
+    num_ambiguous := 0
+    num_override := 0
+    wr.WriteString("# Unmatched\n")
+    for _, word := range list {
+        if word.Entry != nil || word.Override { continue }
+        wr.WriteString(word_str(word) + "\n")
+        num_unmatched += 1
+    }
+    wr.WriteString("\n# Ambiguous\n")
+    for _, word := range list {
+        if !word.Ambiguous() { continue }
+        cand := []string{}
+        for _, item := range word.Cand {
+            cand = append(cand, fmt.Sprintf("%s:%d", item.Entry.Id, item.Score))
+        }
+        wr.WriteString(word_str(word) + "\t" + strings.Join(cand, ";") + "\n")
+        num_ambiguous += 1
+    }
+    wr.WriteString("\n# Overridden\n")
+    for _, word := range list {
+        if !word.Override { continue }
+        id := "-"
+        if word.Entry != nil { id = word.Entry.Id }
+        wr.WriteString(word_str(word) + "\t" + id + "\n")
+        num_override += 1
+    }
+    
+    // Close
+    wr.Flush()
+    fs.Close()
+    
+    // Summary
+    fmt.Printf("JLPT words: %d, unmatched: %d, ambiguous: %d, overridden: %d\n",
+        len(list), num_unmatched, num_ambiguous, num_override)
+}
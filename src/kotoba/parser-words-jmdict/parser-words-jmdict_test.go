@@ -0,0 +1,63 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "testing"
+)
+
+func test_entry(id string, keb []string, reb []string, gloss ...string) *DictEntry {
+    entry := &DictEntry{ Id: id }
+    for _, str := range keb { entry.Kele = append(entry.Kele, DictKele{ Keb: str }) }
+    for _, str := range reb { entry.Rele = append(entry.Rele, DictRele{ Reb: str }) }
+    entry.Sense = []DictSense{ DictSense{ Gloss: gloss } }
+    return entry
+}
+
+// Tanos words against homographs, homophones and variant spellings
+func TestMatcherScore(t *testing.T) {
+    dict := &DictRoot{ Entry: []*DictEntry{
+        test_entry("1", []string{ "上手" }, []string{ "じょうず" }, "skillful", "skilled", "proficient"),
+        test_entry("2", []string{ "上手" }, []string{ "うわて" }, "upper part", "upper stream"),
+        test_entry("3", []string{ "上手" }, []string{ "かみて" }, "upper stream", "upper part of stage"),
+        test_entry("4", []string{ "行く" }, []string{ "いく", "ゆく" }, "to go", "to move"),
+        test_entry("5", []string{ "逝く" }, []string{ "いく", "ゆく" }, "to die", "to pass away"),
+        test_entry("6", []string{ "噓" }, []string{ "うそ" }, "lie", "falsehood"),
+        test_entry("7", []string{ "鷽" }, []string{ "うそ" }, "bullfinch"),
+        test_entry("8", []string{ "個々" }, []string{ "ここ" }, "individual", "one by one"),
+        test_entry("9", []string{}, []string{ "ここ" }, "here", "this place"),
+    } }
+    dict.Entry[0].Kele[0].KePri = []string{ "news1" }
+    matcher := MatcherNew(dict)
+    
+    // Word and expected entry
+    cases := []struct {
+        word WordTanos
+        id string
+    }{
+        { WordTanos{ Kele: []string{ "上手" }, Rele: []string{ "じょうず" }, En: "skillful" }, "1" },
+        { WordTanos{ Kele: []string{ "行く" }, Rele: []string{ "いく" }, En: "to go" }, "4" },
+        { WordTanos{ Kele: []string{ "嘘" }, Rele: []string{ "うそ" }, En: "lie" }, "6" },
+        { WordTanos{ Rele: []string{ "ここ" }, En: "here" }, "9" },
+    }
+    for _, item := range cases {
+        word := item.word
+        matcher.Match(&word)
+        if word.Entry == nil {
+            t.Errorf("%v: no match, expected %s", word.Rele, item.id)
+            continue
+        }
+        if word.Entry.Id != item.id { t.Errorf("%v: matched %s, expected %s", word.Rele, word.Entry.Id, item.id) }
+        if word.Ambiguous() { t.Errorf("%v: ambiguous match", word.Rele) }
+    }
+    
+    // Reading only candidates rank below kanji matches
+    word := WordTanos{ Kele: []string{ "行く" }, Rele: []string{ "いく" }, En: "to go" }
+    if matcher.Score(&word, dict.Entry[4]) >= matcher.Score(&word, dict.Entry[3]) {
+        t.Errorf("reading only candidate not ranked lower")
+    }
+}
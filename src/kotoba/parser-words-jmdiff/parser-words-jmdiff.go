@@ -0,0 +1,392 @@
+//
+// Kotoba
+// Copyright (C) 2013 sh0 <sh0@yutani.ee>
+//
+
+// Package and imports
+package main
+import (
+    // System
+    "flag"
+    "fmt"
+    "os"
+    "io"
+    "bufio"
+    "encoding/xml"
+    "encoding/binary"
+    "strings"
+    "strconv"
+    "sort"
+)
+
+var g_bo binary.ByteOrder
+
+// Dictionary structures
+type DictRoot struct {
+    XMLName xml.Name `xml:"JMdict"`
+    Entry []*DictEntry `xml:"entry"`
+}
+
+type DictEntry struct {
+    Id string `xml:"ent_seq"`
+    Kele []DictKele `xml:"k_ele"`
+    Rele []DictRele `xml:"r_ele"`
+    Sense []DictSense `xml:"sense"`
+}
+
+type DictKele struct {
+    Keb string `xml:"keb"`
+    KeInf []string `xml:"ke_inf"`
+    KePri []string `xml:"ke_pri"`
+}
+
+type DictRele struct {
+    Reb string `xml:"reb"`
+    ReNokanji *struct{} `xml:"re_nokanji"`
+    ReRestr []string `xml:"re_restr"`
+    ReInf []string `xml:"re_inf"`
+    RePri []string `xml:"re_pri"`
+}
+
+type DictSense struct {
+    Stagk []string `xml:"stagk"`
+    Stagr []string `xml:"stagr"`
+    Pos []string `xml:"pos"`
+    Gloss []string `xml:"gloss"`
+}
+
+func (this *DictEntry) Spellings() []string {
+    ret := []string{}
+    for _, kele := range this.Kele { ret = append(ret, kele.Keb) }
+    return ret
+}
+
+func (this *DictEntry) Readings() []string {
+    ret := []string{}
+    for _, rele := range this.Rele { ret = append(ret, rele.Reb) }
+    return ret
+}
+
+func (this *DictEntry) Priority() []string {
+    ret := []string{}
+    for _, kele := range this.Kele {
+        for _, str := range kele.KePri { ret = append(ret, kele.Keb + ":" + str) }
+    }
+    for _, rele := range this.Rele {
+        for _, str := range rele.RePri { ret = append(ret, rele.Reb + ":" + str) }
+    }
+    return ret
+}
+
+// Part of speech of each sense, carried over to following senses until restated
+// as in parser-words-jmdict
+func (this *DictEntry) SensePos() [][]string {
+    ret := [][]string{}
+    pos := []string{}
+    for _, sense := range this.Sense {
+        if len(sense.Pos) > 0 {
+            pos = []string{}
+            for _, str := range sense.Pos {
+                pos = append(pos, strings.Trim(strings.Replace(str, "&", "", -1), ";"))
+            }
+        }
+        ret = append(ret, pos)
+    }
+    return ret
+}
+
+func (this *DictEntry) Title() string {
+    str := this.Id
+    if len(this.Kele) > 0 { str += " " + this.Kele[0].Keb }
+    if len(this.Rele) > 0 { str += " [" + this.Rele[0].Reb + "]" }
+    if len(this.Sense) > 0 && len(this.Sense[0].Gloss) > 0 { str += " " + this.Sense[0].Gloss[0] }
+    return str
+}
+
+func LoadDict(fn string) map[string]*DictEntry {
+    // File
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return nil
+    }
+    defer fs.Close()
+    
+    // XML reader
+    dict := DictRoot{}
+    reader := bufio.NewReader(fs)
+    decoder := xml.NewDecoder(reader)
+    decoder.Strict = false
+    err = decoder.Decode(&dict)
+    if err != nil {
+        fmt.Printf("XML error: %s\n", err.Error())
+        return nil
+    }
+    
+    // Entries by sequence number
+    ret := map[string]*DictEntry{}
+    for _, entry := range dict.Entry { ret[entry.Id] = entry }
+    return ret
+}
+
+// Load JLPT entry numbers from the hash migration map
+func LoadMigmap(fn string) map[string]bool {
+    // File
+    ret := map[string]bool{}
+    fs, err := os.Open(fn)
+    if (err != nil) {
+        fmt.Printf("Failed to open file: %s\n", err.Error())
+        return ret
+    }
+    defer fs.Close()
+    reader := bufio.NewReader(fs)
+    
+    // Size
+    var num uint32
+    if binary.Read(reader, g_bo, &num) != nil { return ret }
+    
+    // Hash and entry pairs
+    hash := make([]byte, 20)
+    for i := uint32(0); i < num; i++ {
+        var id uint32
+        if _, err = io.ReadFull(reader, hash); err != nil { break }
+        if binary.Read(reader, g_bo, &id) != nil { break }
+        ret[strconv.Itoa(int(id))] = true
+    }
+    return ret
+}
+
+// <===> Diff <================================================================>
+func sdiff(a []string, b []string) ([]string, []string) {
+    ma := map[string]bool{}
+    mb := map[string]bool{}
+    for _, str := range a { ma[str] = true }
+    for _, str := range b { mb[str] = true }
+    add := []string{}
+    del := []string{}
+    for _, str := range b {
+        if !ma[str] { add = append(add, str) }
+    }
+    for _, str := range a {
+        if !mb[str] { del = append(del, str) }
+    }
+    return add, del
+}
+
+func schange(name string, a []string, b []string) string {
+    add, del := sdiff(a, b)
+    if len(add) == 0 && len(del) == 0 { return "" }
+    str := "    " + name
+    for _, item := range add { str += " +" + item }
+    for _, item := range del { str += " -" + item }
+    return str + "\n"
+}
+
+type DiffChange struct {
+    Entry *DictEntry
+    Text string
+}
+
+type DiffClass struct {
+    Old map[string]*DictEntry
+    New map[string]*DictEntry
+    Jlpt map[string]bool
+    // Result
+    Added []*DictEntry
+    Deleted []*DictEntry
+    Merged map[*DictEntry]*DictEntry
+    Changed []*DiffChange
+    Affected []string
+}
+
+type DiffIdent []string
+func (list DiffIdent) Len() int { return len(list) }
+func (list DiffIdent) Swap(i, j int) { list[i], list[j] = list[j], list[i] }
+func (list DiffIdent) Less(i, j int) bool {
+    a, _ := strconv.Atoi(list[i])
+    b, _ := strconv.Atoi(list[j])
+    return a < b
+}
+
+func diff_ids(m map[string]*DictEntry) []string {
+    ret := DiffIdent{}
+    for id := range m { ret = append(ret, id) }
+    sort.Sort(ret)
+    return ret
+}
+
+func (this *DiffClass) Execute() {
+    // Reset
+    this.Added = []*DictEntry{}
+    this.Deleted = []*DictEntry{}
+    this.Merged = map[*DictEntry]*DictEntry{}
+    this.Changed = []*DiffChange{}
+    this.Affected = []string{}
+    
+    // Spelling index of the new release
+    index := map[string][]*DictEntry{}
+    for _, id := range diff_ids(this.New) {
+        entry := this.New[id]
+        for _, str := range entry.Spellings() { index[str] = append(index[str], entry) }
+        for _, str := range entry.Readings() { index[str] = append(index[str], entry) }
+    }
+    
+    // Deleted and merged entries
+    for _, id := range diff_ids(this.Old) {
+        entry := this.Old[id]
+        if _, exists := this.New[id]; exists { continue }
+        
+        // Merged when another entry took over the headword and reading
+        var target *DictEntry
+        head := entry.Readings()
+        if len(entry.Kele) > 0 { head = entry.Spellings() }
+        if len(head) == 0 { head = []string{ "" } }
+        for _, cand := range index[head[0]] {
+            _, old := this.Old[cand.Id]
+            rmiss, _ := sdiff(cand.Readings(), entry.Readings())
+            if cand.Id != id && len(rmiss) == 0 && (!old || target == nil) { target = cand }
+        }
+        if target != nil {
+            this.Merged[entry] = target
+        } else {
+            this.Deleted = append(this.Deleted, entry)
+        }
+        if this.Jlpt[id] {
+            reason := "deleted"
+            if target != nil { reason = "merged into " + target.Id }
+            this.Affected = append(this.Affected, entry.Title() + ": " + reason)
+        }
+    }
+    
+    // Added entries and new homographs of JLPT words
+    for _, id := range diff_ids(this.New) {
+        entry := this.New[id]
+        if _, exists := this.Old[id]; exists { continue }
+        this.Added = append(this.Added, entry)
+        head := entry.Readings()
+        if len(entry.Kele) > 0 { head = entry.Spellings() }
+        for _, str := range head {
+            for _, cand := range index[str] {
+                if cand != entry && this.Jlpt[cand.Id] {
+                    this.Affected = append(this.Affected, cand.Title() + ": new homograph " + entry.Id)
+                }
+            }
+        }
+    }
+    
+    // Changed entries
+    for _, id := range diff_ids(this.New) {
+        entry := this.New[id]
+        old, exists := this.Old[id]
+        if !exists { continue }
+        
+        // Spellings, readings and priorities
+        str := ""
+        str += schange("kanji", old.Spellings(), entry.Spellings())
+        str += schange("kana", old.Readings(), entry.Readings())
+        str += schange("pri", old.Priority(), entry.Priority())
+        
+        // Part of speech and glosses per sense
+        old_pos := old.SensePos()
+        new_pos := entry.SensePos()
+        for i := 0; i < len(old.Sense) || i < len(entry.Sense); i++ {
+            a := []string{}
+            b := []string{}
+            if i < len(old.Sense) { a = old_pos[i] }
+            if i < len(entry.Sense) { b = new_pos[i] }
+            str += schange("pos " + strconv.Itoa(i + 1), a, b)
+            a = []string{}
+            b = []string{}
+            if i < len(old.Sense) { a = old.Sense[i].Gloss }
+            if i < len(entry.Sense) { b = entry.Sense[i].Gloss }
+            str += schange("gloss " + strconv.Itoa(i + 1), a, b)
+        }
+        if len(str) == 0 { continue }
+        
+        this.Changed = append(this.Changed, &DiffChange{ Entry: entry, Text: str })
+        if this.Jlpt[id] {
+            this.Affected = append(this.Affected, entry.Title() + ": changed")
+        }
+    }
+}
+
+func (this *DiffClass) Save(fn string) {
+    // File
+    fs, err := os.OpenFile(fn, os.O_WRONLY | os.O_TRUNC | os.O_CREATE, 0644)
+    if (err != nil) {
+        fmt.Printf("Failed to open diff output file: %s\n", err.Error())
+        return
+    }
+    wr := bufio.NewWriter(fs)
+    
+    // JLPT marker
+    mark := func(entry *DictEntry) string {
+        if this.Jlpt[entry.Id] { return " (JLPT)" }
+        return ""
+    }
+    
+    // Sections
+    fmt.Fprintf(wr, "# Added (%d)\n", len(this.Added))
+    for _, entry := range this.Added {
+        fmt.Fprintf(wr, "+ %s\n", entry.Title())
+    }
+    fmt.Fprintf(wr, "\n# Deleted (%d)\n", len(this.Deleted))
+    for _, entry := range this.Deleted {
+        fmt.Fprintf(wr, "- %s%s\n", entry.Title(), mark(entry))
+    }
+    fmt.Fprintf(wr, "\n# Merged (%d)\n", len(this.Merged))
+    for _, id := range diff_ids(this.Old) {
+        entry := this.Old[id]
+        target, exists := this.Merged[entry]
+        if !exists { continue }
+        fmt.Fprintf(wr, "> %s%s -> %s\n", entry.Title(), mark(entry), target.Id)
+    }
+    fmt.Fprintf(wr, "\n# Changed (%d)\n", len(this.Changed))
+    for _, change := range this.Changed {
+        fmt.Fprintf(wr, "* %s%s\n%s", change.Entry.Title(), mark(change.Entry), change.Text)
+    }
+    fmt.Fprintf(wr, "\n# JLPT matching affected (%d)\n", len(this.Affected))
+    for _, str := range this.Affected {
+        fmt.Fprintf(wr, "! %s\n", str)
+    }
+    
+    // Close
+    wr.Flush()
+    fs.Close()
+}
+
+// Main
+func main() {
+    // Flags
+    fn_old := flag.String("old", "", "Previous JMdict file")
+    fn_new := flag.String("new", "", "Current JMdict file")
+    fn_mig := flag.String("migmap", "out-migmap.kdb", "Hash migration map with JLPT entries")
+    fn_out := flag.String("out", "out-jmdict-diff.txt", "Changelog output file")
+    flag.Parse()
+    if (*fn_old == "" || *fn_new == "") {
+        fmt.Printf("Please specify both old and new JMdict files!\n")
+        return
+    }
+    
+    // Byte order
+    g_bo = binary.LittleEndian
+    
+    // Load
+    diff := DiffClass{}
+    fmt.Printf("Reading old...\n")
+    diff.Old = LoadDict(*fn_old)
+    if diff.Old == nil { return }
+    fmt.Printf("Reading new...\n")
+    diff.New = LoadDict(*fn_new)
+    if diff.New == nil { return }
+    diff.Jlpt = LoadMigmap(*fn_mig)
+    
+    // Compare
+    fmt.Printf("Comparing...\n")
+    diff.Execute()
+    fmt.Printf("Added: %d, deleted: %d, merged: %d, changed: %d, JLPT affected: %d\n",
+        len(diff.Added), len(diff.Deleted), len(diff.Merged), len(diff.Changed), len(diff.Affected))
+    
+    // Save
+    diff.Save(*fn_out)
+}